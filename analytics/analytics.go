@@ -0,0 +1,301 @@
+package analytics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"uwo-tt-api/logger"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Redacted replaces the values of redacted headers, query parameters and body fields
+const Redacted = "[REDACTED]"
+
+// maxBody is the largest body recorded, larger ones such as dataset downloads are left out
+const maxBody = 64 << 10
+
+// queueSize is how many events may wait for the sink before new ones are dropped
+const queueSize = 1000
+
+// Event is a request made to the API and the response it got
+type Event struct {
+	RequestID string    `json:"requestId" bson:"requestId"`
+	Time      time.Time `json:"time" bson:"time"`
+	// Duration is the time in seconds taken to serve the request
+	Duration        float64             `json:"duration" bson:"duration"`
+	Method          string              `json:"method" bson:"method"`
+	Host            string              `json:"host" bson:"host"`
+	URL             string              `json:"url" bson:"url"`
+	Route           string              `json:"route" bson:"route"`
+	IP              string              `json:"ip" bson:"ip"`
+	RequestHeaders  map[string][]string `json:"requestHeaders" bson:"requestHeaders"`
+	RequestBody     interface{}         `json:"requestBody,omitempty" bson:"requestBody,omitempty"`
+	Status          int                 `json:"status" bson:"status"`
+	ResponseHeaders map[string][]string `json:"responseHeaders" bson:"responseHeaders"`
+	ResponseBody    interface{}         `json:"responseBody,omitempty" bson:"responseBody,omitempty"`
+	// Weight is how many requests the event stands for when only a sample is recorded
+	Weight int `json:"weight" bson:"weight"`
+}
+
+// Sink receives the events recorded, one at a time
+type Sink interface {
+	Record(event Event) error
+	// Close sends or writes the events still held by the sink
+	Close() error
+}
+
+// Nop is the sink of a server that records no analytics
+type Nop struct{}
+
+// Record drops the event
+func (Nop) Record(Event) error { return nil }
+
+// Close does nothing
+func (Nop) Close() error { return nil }
+
+// Settings are what is recorded of each request
+type Settings struct {
+	// LogBody records JSON request and response bodies
+	LogBody bool
+	// Redact names the headers, query parameters and body fields whose values are replaced, in any case
+	Redact []string
+	// SampleRate is the share of requests recorded, from 0 to 1
+	SampleRate float64
+}
+
+// Recorder records the requests to the API in a sink. Events are handed to the sink in the background, and dropped if the
+// sink falls behind so requests never wait on it
+type Recorder struct {
+	sink     Sink
+	settings Settings
+	redact   map[string]bool
+	events   chan Event
+	done     chan struct{}
+
+	// mu guards sending events against closing, as requests may still be served once the recorder is closed
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewRecorder starts recording into sink
+func NewRecorder(sink Sink, settings Settings) *Recorder {
+	r := &Recorder{
+		sink:     sink,
+		settings: settings,
+		redact:   map[string]bool{},
+		events:   make(chan Event, queueSize),
+		done:     make(chan struct{}),
+	}
+
+	for _, name := range settings.Redact {
+		r.redact[strings.ToLower(name)] = true
+	}
+
+	go r.run()
+
+	return r
+}
+
+// run hands the events to the sink until the recorder is closed
+func (r *Recorder) run() {
+	defer close(r.done)
+
+	for event := range r.events {
+		if err := r.sink.Record(event); err != nil {
+			logrus.WithField("request_id", event.RequestID).WithError(err).Warn("Failed to record analytics event")
+		}
+	}
+}
+
+// Close stops recording once the events queued have reached the sink, then closes the sink. It gives up once ctx is done.
+// Requests served after are not recorded
+func (r *Recorder) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+
+	closed := make(chan error, 1)
+	go func() {
+		<-r.done
+		closed <- r.sink.Close()
+	}()
+
+	select {
+	case err := <-closed:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Middleware records a sample of the requests it serves, with the values to redact replaced. A server without analytics
+// records nothing
+func (r *Recorder) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, off := r.sink.(Nop); off || !r.sampled() {
+			c.Next()
+			return
+		}
+
+		var requestBody []byte
+		if r.settings.LogBody && c.Request.Body != nil {
+			requestBody, _ = ioutil.ReadAll(c.Request.Body)
+			c.Request.Body = ioutil.NopCloser(bytes.NewReader(requestBody))
+		}
+
+		writer := &bodyWriter{ResponseWriter: c.Writer, record: r.settings.LogBody}
+		c.Writer = writer
+
+		start := time.Now()
+		c.Next()
+
+		event := Event{
+			RequestID:       c.Writer.Header().Get(logger.RequestIDHeader),
+			Time:            start.UTC(),
+			Duration:        time.Since(start).Seconds(),
+			Method:          c.Request.Method,
+			Host:            c.Request.Host,
+			URL:             r.redactURL(c.Request.URL),
+			Route:           c.FullPath(),
+			IP:              c.ClientIP(),
+			RequestHeaders:  r.redactHeaders(c.Request.Header),
+			RequestBody:     r.redactBody(requestBody),
+			Status:          c.Writer.Status(),
+			ResponseHeaders: r.redactHeaders(c.Writer.Header()),
+			Weight:          int(math.Round(1 / r.settings.SampleRate)),
+		}
+
+		if !writer.overflow {
+			event.ResponseBody = r.redactBody(writer.body.Bytes())
+		}
+
+		r.queue(c.Request.Context(), event)
+	}
+}
+
+// queue hands an event to the sink unless the queue is full or the recorder is closed
+func (r *Recorder) queue(ctx context.Context, event Event) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		logger.From(ctx).Debug("Analytics recorder is closed, dropped event")
+		return
+	}
+
+	select {
+	case r.events <- event:
+	default:
+		logger.From(ctx).Warn("Analytics queue is full, dropped event")
+	}
+}
+
+// sampled decides if a request is recorded
+func (r *Recorder) sampled() bool {
+	return r.settings.SampleRate >= 1 || rand.Float64() < r.settings.SampleRate
+}
+
+// redactURL returns the path and query of a url, with the values of redacted parameters replaced
+func (r *Recorder) redactURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		if r.redact[strings.ToLower(name)] {
+			query[name] = []string{Redacted}
+		}
+	}
+
+	if len(query) == 0 {
+		return u.Path
+	}
+
+	return u.Path + "?" + query.Encode()
+}
+
+// redactHeaders copies headers, with the values of redacted headers replaced
+func (r *Recorder) redactHeaders(header http.Header) map[string][]string {
+	headers := make(map[string][]string, len(header))
+	for name, values := range header {
+		if r.redact[strings.ToLower(name)] {
+			values = []string{Redacted}
+		}
+
+		headers[name] = values
+	}
+
+	return headers
+}
+
+// redactBody decodes a JSON body, with the values of redacted fields replaced at any depth. Other bodies are left out
+func (r *Recorder) redactBody(body []byte) interface{} {
+	var doc interface{}
+	if len(body) == 0 || json.Unmarshal(body, &doc) != nil {
+		return nil
+	}
+
+	return r.redactValue(doc)
+}
+
+func (r *Recorder) redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if r.redact[strings.ToLower(key)] {
+				value[key] = Redacted
+			} else {
+				value[key] = r.redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i, elem := range value {
+			value[i] = r.redactValue(elem)
+		}
+	}
+
+	return v
+}
+
+// bodyWriter keeps a copy of the response body written, unless it grows past maxBody
+type bodyWriter struct {
+	gin.ResponseWriter
+	record   bool
+	body     bytes.Buffer
+	overflow bool
+}
+
+func (w *bodyWriter) Write(b []byte) (int, error) {
+	w.keep(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyWriter) WriteString(s string) (int, error) {
+	w.keep([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// keep copies part of the body, giving up on bodies that are too large
+func (w *bodyWriter) keep(b []byte) {
+	if !w.record || w.overflow {
+		return
+	}
+
+	if w.body.Len()+len(b) > maxBody {
+		w.overflow = true
+		w.body.Reset()
+		return
+	}
+
+	w.body.Write(b)
+}
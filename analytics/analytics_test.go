@@ -0,0 +1,82 @@
+package analytics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// memorySink keeps the events recorded
+type memorySink struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (s *memorySink) Record(event Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, event)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func (s *memorySink) count() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.events)
+}
+
+// TestRecorderDropsLateEvents serves requests while the recorder is closed, as a server does when its shutdown times out
+func TestRecorderDropsLateEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	sink := &memorySink{}
+	recorder := NewRecorder(sink, Settings{SampleRate: 1})
+
+	router := gin.New()
+	router.Use(recorder.Middleware())
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	serve := func() {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	serve()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve()
+		}()
+	}
+
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+
+	recorded := sink.count()
+	if recorded == 0 {
+		t.Fatal("no event was recorded before closing")
+	}
+
+	serve()
+
+	if err := recorder.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if sink.count() != recorded {
+		t.Errorf("recorded %d events after closing", sink.count()-recorded)
+	}
+}
@@ -0,0 +1,42 @@
+package analytics
+
+import (
+	"encoding/json"
+	"sync"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// File writes the events to a file as JSON, one per line. Once the file grows past its size it is rotated, keeping a number
+// of old files
+type File struct {
+	mu     sync.Mutex
+	writer *lumberjack.Logger
+}
+
+// NewFile creates a sink writing to the file at path, rotated once it holds maxSize megabytes, keeping backups old files
+func NewFile(path string, maxSize int, backups int) *File {
+	return &File{writer: &lumberjack.Logger{Filename: path, MaxSize: maxSize, MaxBackups: backups}}
+}
+
+// Record appends the event to the file
+func (f *File) Record(event Event) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err = f.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.writer.Close()
+}
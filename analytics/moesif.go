@@ -0,0 +1,67 @@
+package analytics
+
+import (
+	"time"
+
+	moesifapi "github.com/moesif/moesifapi-go"
+	"github.com/moesif/moesifapi-go/models"
+)
+
+// Moesif sends the events to Moesif, which batches them in the background
+type Moesif struct {
+	api moesifapi.API
+}
+
+// NewMoesif creates a sink sending events to the Moesif application
+func NewMoesif(applicationID string) *Moesif {
+	return &Moesif{api: moesifapi.NewAPI(applicationID)}
+}
+
+// Record queues the event to be sent with the next batch
+func (m *Moesif) Record(event Event) error {
+	requestTime := event.Time
+	responseTime := event.Time.Add(time.Duration(event.Duration * float64(time.Second)))
+	direction := "Incoming"
+	weight := event.Weight
+
+	var requestBody interface{} = event.RequestBody
+	encoding := "json"
+
+	// Moesif links the events of a request by its transaction id, which is the id the server gave it
+	requestHeaders := map[string][]string{"X-Moesif-Transaction-Id": {event.RequestID}}
+	for name, values := range event.RequestHeaders {
+		requestHeaders[name] = values
+	}
+
+	scheme := "http"
+	if proto := event.RequestHeaders["X-Forwarded-Proto"]; len(proto) > 0 && proto[0] == "https" {
+		scheme = "https"
+	}
+
+	return m.api.QueueEvent(&models.EventModel{
+		Request: models.EventRequestModel{
+			Time:             &requestTime,
+			Uri:              scheme + "://" + event.Host + event.URL,
+			Verb:             event.Method,
+			Headers:          requestHeaders,
+			IpAddress:        &event.IP,
+			Body:             &requestBody,
+			TransferEncoding: &encoding,
+		},
+		Response: models.EventResponseModel{
+			Time:             &responseTime,
+			Status:           event.Status,
+			Headers:          event.ResponseHeaders,
+			Body:             event.ResponseBody,
+			TransferEncoding: &encoding,
+		},
+		Direction: &direction,
+		Weight:    &weight,
+	})
+}
+
+// Close sends the events still queued
+func (m *Moesif) Close() error {
+	m.api.Close()
+	return nil
+}
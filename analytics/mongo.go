@@ -0,0 +1,38 @@
+package analytics
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// RequestCollection is the collection the Mongo sink records requests in
+const RequestCollection = "requests"
+
+// writeTimeout is how long writing an event may take
+const writeTimeout = 10 * time.Second
+
+// Mongo records the events as documents of a MongoDB collection
+type Mongo struct {
+	collection *mongo.Collection
+}
+
+// NewMongo creates a sink recording events in the requests collection of the database
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{collection: db.Collection(RequestCollection)}
+}
+
+// Record inserts the event
+func (m *Mongo) Record(event Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	_, err := m.collection.InsertOne(ctx, event)
+	return err
+}
+
+// Close does nothing, as the database is closed with the server
+func (m *Mongo) Close() error {
+	return nil
+}
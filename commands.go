@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"uwo-tt-api/config"
+	"uwo-tt-api/dataset"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+	"uwo-tt-api/worker"
+
+	"github.com/sirupsen/logrus"
+)
+
+// command is a subcommand of the binary, sharing the configuration of every other command
+type command struct {
+	name  string
+	usage string
+	help  string
+	run   func(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string)
+}
+
+// commands are the subcommands of the binary, in the order they are listed
+var commands = []command{
+	{"serve", "serve [--scrape] [--dataset file]", "Serve the API, scraping on the schedule with --scrape", serveCommand},
+	{"scrape", "scrape [--subject SUBJECT]...", "Scrape once then exit, only the given subjects with --subject", scrapeCommand},
+	{"export", "export <file>", "Export the live data as a dataset file", exportCommand},
+	{"import", "import <file>", "Import a dataset file into an empty database", importCommand},
+	{"status", "status", "Show the live data, any interrupted scrape and the next scheduled scrape", statusCommand},
+	{"generations", "generations", "List the stored generations, newest first", generationsCommand},
+	{"rollback", "rollback <generation>", "Serve a previously published generation again", rollbackCommand},
+	{"schedule", "schedule [n]", "List the next n scheduled scrapes, 10 by default", scheduleCommand},
+	{"config", "config", "Print the configuration, hiding secrets", configCommand},
+}
+
+// printUsage prints every command and the options they share
+func printUsage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] <command> [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-36s %s\n", cmd.usage, cmd.help)
+	}
+
+	fmt.Fprintf(os.Stderr, "\nOptions, which override the config file and environment:\n")
+	flag.PrintDefaults()
+}
+
+// newFlags creates the flags of a command, which print its usage on -h
+func newFlags(cmd command) *flag.FlagSet {
+	flags := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s\n\n%s\n", os.Args[0], cmd.usage, cmd.help)
+		flags.PrintDefaults()
+	}
+
+	return flags
+}
+
+// parseArgs parses the flags of a command, exiting with its usage unless it has from min to max positional arguments
+func parseArgs(flags *flag.FlagSet, args []string, min int, max int) []string {
+	flags.Parse(args)
+
+	if flags.NArg() < min || flags.NArg() > max {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	return flags.Args()
+}
+
+// subjectList collects subjects from a flag that can be repeated or comma separated
+type subjectList []string
+
+func (s *subjectList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *subjectList) Set(value string) error {
+	for _, subject := range strings.Split(value, ",") {
+		if subject = strings.ToUpper(strings.TrimSpace(subject)); subject != "" {
+			*s = append(*s, subject)
+		}
+	}
+
+	return nil
+}
+
+// serveCommand serves the API, from a dataset file in memory or from the database
+func serveCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	scrape := flags.Bool("scrape", false, "Scrape on the schedule while serving")
+	datasetFile := flags.String("dataset", cfg.DatasetFile, "Serve a dataset file from memory instead of the database, also set by DATASET_FILE")
+	parseArgs(flags, args, 0, 0)
+
+	// Serve a dataset snapshot from memory when one is configured, so no database is needed
+	if *datasetFile != "" {
+		if *scrape {
+			logrus.Warn("Scraping is off while serving a dataset from memory")
+		}
+
+		serve(ctx, cfg, loadSnapshot(ctx, *datasetFile))
+		return
+	}
+
+	repo := connectRepository(ctx, cfg)
+	if *scrape {
+		startScraping(ctx, cfg, repo)
+	}
+
+	serve(ctx, cfg, repo)
+
+	// A scrape stops after the subject it is writing, so it can be resumed
+	waitForScrapes(cfg.ShutdownTimeout)
+}
+
+// scrapeCommand scrapes once, exiting with an error status unless the scrape is published. It exits successfully without
+// scraping while another scrape is running, but not when the lease cannot be taken
+func scrapeCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	var subjects subjectList
+	flags.Var(&subjects, "subject", "Scrape only this subject and merge it into the live data, repeated or comma separated")
+	parseArgs(flags, args, 0, 0)
+
+	repo := connectRepository(ctx, cfg)
+
+	var run model.Run
+	ran, err := scrapeExclusively(ctx, repo, cfg.ScrapeLeaseTTL, func(ctx context.Context) { run = scrapeOnce(ctx, cfg, repo, subjects) })
+	if err != nil {
+		os.Exit(1)
+	} else if !ran {
+		return
+	}
+
+	if run.Status != model.RunPublished {
+		os.Exit(1)
+	}
+}
+
+// exportCommand writes the live data to a dataset file
+func exportCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	path := parseArgs(flags, args, 1, 1)[0]
+
+	repo := connectRepository(ctx, cfg)
+
+	snapshot, err := repo.Snapshot(ctx)
+	if err != nil {
+		log.Fatalf("Failed to read the live generation: %s", err)
+	}
+
+	run, err := snapshot.Run(ctx)
+	if errors.Is(err, store.ErrNotFound) {
+		log.Fatal("No dataset has been published yet")
+	} else if err != nil {
+		log.Fatalf("Failed to find live scrape run: %s", err)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := dataset.Export(ctx, snapshot, run, f); err != nil {
+		f.Close()
+		log.Fatalf("Failed to export dataset: %s", err)
+	}
+
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("Exported dataset of scrape run %s to %s\n", run.ID, path)
+}
+
+// importCommand loads a dataset snapshot file into the database
+func importCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	path := parseArgs(flags, args, 1, 1)[0]
+
+	repo := connectRepository(ctx, cfg)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer f.Close()
+
+	run, err := dataset.Import(ctx, repo, f)
+	if err != nil {
+		log.Fatalf("Failed to import dataset: %s", err)
+	}
+
+	fmt.Printf("Imported dataset of scrape run %s\n", run.ID)
+}
+
+// statusCommand prints the live data, any interrupted scrape and the next scheduled scrape
+func statusCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	parseArgs(flags, args, 0, 0)
+
+	schedule, err := cfg.Schedule()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	repo := connectRepository(ctx, cfg)
+
+	run, err := repo.LatestRun(ctx)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Println("Live data:\tnone published yet")
+	} else if err != nil {
+		log.Fatalf("Failed to find live scrape run: %s", err)
+	} else {
+		fmt.Printf("Live data:\tgeneration %d of run %s, finished %s\n", run.Generation, run.ID, run.Finished.Format(time.RFC3339))
+		fmt.Printf("\t\t%d subjects, %d sections\n", run.Subjects, run.Sections)
+	}
+
+	unfinished, err := repo.UnfinishedRun(ctx, false)
+	if errors.Is(err, store.ErrNotFound) {
+		fmt.Println("Running scrape:\tnone")
+	} else if err != nil {
+		log.Fatalf("Failed to find unfinished scrape run: %s", err)
+	} else {
+		fmt.Printf("Running scrape:\trun %s into generation %d, started %s, %d subjects written\n",
+			unfinished.ID, unfinished.Generation, unfinished.Started.Format(time.RFC3339), len(unfinished.Checkpoints))
+	}
+
+	// A scrape of a few subjects is only listed while it runs, as it is never resumed
+	targeted, err := repo.UnfinishedRun(ctx, true)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Fatalf("Failed to find unfinished scrape run: %s", err)
+	} else if err == nil {
+		fmt.Printf("Subject scrape:\trun %s of %s into generation %d, started %s\n",
+			targeted.ID, strings.Join(targeted.Targets, ", "), targeted.Generation, targeted.Started.Format(time.RFC3339))
+	}
+
+	if next, ok := schedule.Next(time.Now()); ok {
+		fmt.Printf("Next scrape:\t%s, %s\n", next.Time.Format(time.RFC3339), describeSubjects(next.Subjects))
+	} else {
+		fmt.Println("Next scrape:\tnone scheduled")
+	}
+}
+
+// generationsCommand prints the stored generations, newest first
+func generationsCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	parseArgs(flags, args, 0, 0)
+
+	gens, err := connectRepository(ctx, cfg).Generations(ctx)
+	if err != nil {
+		log.Fatalf("Failed to list generations: %s", err)
+	}
+
+	for _, gen := range gens {
+		status := "unpublished"
+		if gen.Live {
+			status = "live"
+		} else if !gen.Published.IsZero() {
+			status = "published " + gen.Published.Format(time.RFC3339)
+		}
+
+		fmt.Printf("%d\trun %s\tcreated %s\t%s\n", gen.Number, gen.Run, gen.Created.Format(time.RFC3339), status)
+	}
+}
+
+// rollbackCommand serves a previously published generation again
+func rollbackCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	arg := parseArgs(flags, args, 1, 1)[0]
+
+	number, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid generation %s", arg)
+	}
+
+	if err := store.Rollback(ctx, connectRepository(ctx, cfg), number); err != nil {
+		log.Fatalf("Failed to roll back: %s", err)
+	}
+
+	fmt.Printf("Generation %d is live\n", number)
+}
+
+// scheduleCommand prints the next scheduled scrapes, 10 unless a number is given
+func scheduleCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	args = parseArgs(flags, args, 0, 1)
+
+	n := 10
+	if len(args) > 0 {
+		var err error
+		if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+			log.Fatalf("Invalid number of scrapes %s", args[0])
+		}
+	}
+
+	schedule, err := cfg.Schedule()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, run := range schedule.Upcoming(time.Now(), n) {
+		fmt.Printf("%s\t%s\n", run.Time.Format(time.RFC3339), describeSubjects(run.Subjects))
+	}
+}
+
+// describeSubjects names the subjects of a scrape, which are all subjects if there are none
+func describeSubjects(subjects []string) string {
+	if len(subjects) == 0 {
+		return "all subjects"
+	}
+
+	return strings.Join(subjects, ", ")
+}
+
+// scrapeOnce scrapes every subject, or only the given subjects merged into the live data
+func scrapeOnce(ctx context.Context, cfg config.Config, repo store.Repository, subjects []string) model.Run {
+	var run model.Run
+	var err error
+
+	if len(subjects) == 0 {
+		run, err = worker.ScrapeTimeTable(ctx, repo, cfg.Scrape())
+	} else {
+		run, err = worker.ScrapeSubjects(ctx, repo, subjects, cfg.Scrape())
+	}
+
+	if errors.Is(err, context.Canceled) {
+		logrus.WithField("run", run.ID).Info("Scrape stopped, the next scrape resumes it")
+		return run
+	} else if err != nil {
+		logrus.WithField("run", run.ID).WithError(err).Error("Failed to scrape")
+		return run
+	}
+
+	logrus.WithFields(logrus.Fields{"run": run.ID, "status": run.Status}).Infof("Run of %s is %s", describeSubjects(subjects), run.Status)
+
+	return run
+}
+
+// scrapes are the scrapes running in the background, waited for on shutdown
+var scrapes sync.WaitGroup
+
+// waitForScrapes waits up to timeout for the scrapes running in the background to stop
+func waitForScrapes(timeout time.Duration) {
+	stopped := make(chan struct{})
+	go func() {
+		scrapes.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(timeout):
+		logrus.Warn("Gave up waiting for the scrape to stop, it is resumed once its lease expires")
+	}
+}
+
+// startScrapeSubjects scrapes the given subjects once in the background while holding the scrape lease. It reports false
+// without scraping if another scrape is running
+func startScrapeSubjects(ctx context.Context, cfg config.Config, repo store.Repository, subjects []string) (bool, error) {
+	lease, err := worker.TakeLease(ctx, repo, worker.ScrapeLease, cfg.ScrapeLeaseTTL)
+	if lease == nil {
+		return false, err
+	}
+
+	scrapes.Add(1)
+	go func() {
+		defer scrapes.Done()
+		lease.Run(ctx, func(ctx context.Context) { scrapeOnce(ctx, cfg, repo, subjects) })
+	}()
+
+	return true, nil
+}
+
+// scrapeExclusively runs a scrape while holding the scrape lease, reporting false if it was skipped as another scrape is running,
+// or an error if the lease could not be taken. The scrape is stopped if the lease is lost
+func scrapeExclusively(ctx context.Context, repo store.Repository, ttl time.Duration, scrape func(ctx context.Context)) (bool, error) {
+	scrapes.Add(1)
+	defer scrapes.Done()
+
+	ran, err := worker.WithLease(ctx, repo, worker.ScrapeLease, ttl, scrape)
+	if err != nil {
+		logrus.WithError(err).Error("Failed to acquire scrape lease")
+	} else if !ran {
+		logrus.Info("Another scrape is running, skipped")
+	}
+
+	return ran, err
+}
+
+// configCommand prints the configuration every command runs with, hiding tokens and database passwords
+func configCommand(ctx context.Context, cfg config.Config, flags *flag.FlagSet, args []string) {
+	parseArgs(flags, args, 0, 0)
+
+	cfg.Print(os.Stdout)
+}
@@ -0,0 +1,95 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"uwo-tt-api/store"
+)
+
+// ScrapeRequest selects the subjects to scrape again
+type ScrapeRequest struct {
+	Subjects []string `json:"subjects" example:"COMPSCI"`
+}
+
+// ScrapeSubjects godoc
+// @Summary Scrape subjects again
+// @Description Scrapes only the given subjects again in the background and merges them into the live data, keeping the sections of every other subject. Refused while another scrape is running. Requires the admin token as a bearer token
+// @Tags admin
+// @ID admin-scrape
+// @Accept json
+// @Produce json
+// @Param Authorization header string true "Bearer admin token"
+// @Param subjects body ScrapeRequest true "Subjects to scrape"
+// @Success 202 {object} ScrapeRequest
+// @Failure 400 {object} HTTPError
+// @Failure 401 {object} HTTPError
+// @Failure 409 {object} HTTPError
+// @Failure 500 {object} HTTPError
+// @Router /admin/scrape [post]
+func (c *Controller) ScrapeSubjects(w http.ResponseWriter, r *http.Request) {
+	HitEndpoint(r, "admin/scrape")
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+
+	if !c.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w = NewError(w, r, http.StatusUnauthorized, errors.New("A valid admin token is required"), "Unauthorized admin request")
+		return
+	}
+
+	var req ScrapeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w = NewError(w, r, http.StatusBadRequest, errors.New("Request body must be a JSON object with a list of subjects"), "Bad scrape request")
+		return
+	}
+
+	if len(req.Subjects) == 0 {
+		w = NewError(w, r, http.StatusBadRequest, errors.New("No subjects to scrape"), "Bad scrape request")
+		return
+	}
+
+	// Only subjects of the live timetable can be merged into it
+	for i, subject := range req.Subjects {
+		subject = strings.ToUpper(strings.TrimSpace(subject))
+		req.Subjects[i] = subject
+
+		filter := store.Filter{Conditions: []store.Condition{{Field: "data.value", Op: store.Eq, Value: subject}}}
+		count, err := c.Repo.CountOptions(r.Context(), "subjects", filter)
+		if err != nil {
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+			return
+		} else if count == 0 {
+			w = NewError(w, r, http.StatusBadRequest, fmt.Errorf("Unknown subject %s", subject), "Bad scrape request")
+			return
+		}
+	}
+
+	started, err := c.Rescrape(req.Subjects)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to start scrape")
+		return
+	} else if !started {
+		w = NewError(w, r, http.StatusConflict, errors.New("Another scrape is running"), "Scrape refused")
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(req)
+}
+
+// authorized reports if the request carries the admin token as a bearer token. Without an admin token no request is authorized
+func (c *Controller) authorized(r *http.Request) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	token := strings.TrimPrefix(header, "Bearer ")
+
+	return c.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"uwo-tt-api/model"
+)
+
+func TestScrapeSubjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		start  func(subjects []string) (bool, error)
+		status int
+	}{
+		{"started", "Bearer secret", `{"subjects": ["cs"]}`, nil, http.StatusAccepted},
+		{"no token", "", `{"subjects": ["CS"]}`, nil, http.StatusUnauthorized},
+		{"token without scheme", "secret", `{"subjects": ["CS"]}`, nil, http.StatusUnauthorized},
+		{"other scheme", "Basic secret", `{"subjects": ["CS"]}`, nil, http.StatusUnauthorized},
+		{"wrong token", "Bearer public", `{"subjects": ["CS"]}`, nil, http.StatusUnauthorized},
+		{"unknown subject", "Bearer secret", `{"subjects": ["BIO"]}`, nil, http.StatusBadRequest},
+		{"no subjects", "Bearer secret", `{"subjects": []}`, nil, http.StatusBadRequest},
+		{
+			"scrape running", "Bearer secret", `{"subjects": ["CS"]}`,
+			func([]string) (bool, error) { return false, nil }, http.StatusConflict,
+		},
+		{
+			"lease unavailable", "Bearer secret", `{"subjects": ["CS"]}`,
+			func([]string) (bool, error) { return false, errors.New("database down") }, http.StatusInternalServerError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newTestController(t)
+			c.AdminToken = "secret"
+
+			gens, err := c.Repo.Generations(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			opts := []model.Option{{Data: model.OptionData{Value: "CS"}}, {Data: model.OptionData{Value: "MATH"}}}
+			if err := c.Repo.WriteOptions(context.Background(), gens[0].Number, "subjects", opts); err != nil {
+				t.Fatal(err)
+			}
+
+			var scraped []string
+			c.Rescrape = func(subjects []string) (bool, error) {
+				scraped = subjects
+				if test.start != nil {
+					return test.start(subjects)
+				}
+
+				return true, nil
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/scrape", strings.NewReader(test.body))
+			if test.auth != "" {
+				r.Header.Set("Authorization", test.auth)
+			}
+
+			w := httptest.NewRecorder()
+			c.ScrapeSubjects(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("answered %d: %s, want %d", w.Code, w.Body, test.status)
+			}
+
+			if test.status == http.StatusAccepted && (len(scraped) != 1 || scraped[0] != "CS") {
+				t.Errorf("scraped %v, want CS", scraped)
+			}
+		})
+	}
+}
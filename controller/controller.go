@@ -1,21 +1,29 @@
 package controller
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/store"
 
 	"github.com/gorilla/schema"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // Controller struct which acts as base for all endpoint methods
 type Controller struct {
-	DB *mongo.Database
+	Repo store.Repository
+	// AdminToken authorizes admin requests, which are refused when it is empty
+	AdminToken string
+	// Rescrape starts scraping the given subjects again in the background, merging them into the live data. It reports false
+	// without scraping if another scrape is running
+	Rescrape func(subjects []string) (bool, error)
+	// MaxAge is how old the live data may be before it is reported as stale, never if it is 0
+	MaxAge time.Duration
 }
 
 // NewController example
@@ -24,18 +32,32 @@ func NewController() *Controller {
 }
 
 // HitEndpoint simple helper to log when an endpoint was hit
-func HitEndpoint(name string) {
-	fmt.Printf("*** ENDPOINT RESOURCE HIT --> %s\n", name)
+func HitEndpoint(r *http.Request, name string) {
+	logger.From(r.Context()).WithField("endpoint", name).Debug("Endpoint hit")
 }
 
-// FilterToDBOp lookup table for query parameter filter commands to mongo command
-var FilterToDBOp = map[string]string{
-	"exact":  "$eq",
-	"except": "$ne",
-	"gt":     "$gt",
-	"gte":    "$gte",
-	"lt":     "$lt",
-	"lte":    "$lte",
+// pathParamsKey is the request context key holding the router's path parameters
+type pathParamsKey struct{}
+
+// WithPathParams returns a copy of the request carrying the path parameters matched by the router
+func WithPathParams(r *http.Request, params map[string]string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+}
+
+// PathParam returns the named path parameter of the request, or an empty string if it does not exist
+func PathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+// FilterToDBOp lookup table for query parameter filter commands to store comparisons
+var FilterToDBOp = map[string]store.Op{
+	"exact":  store.Eq,
+	"except": store.Ne,
+	"gt":     store.Gt,
+	"gte":    store.Gte,
+	"lt":     store.Lt,
+	"lte":    store.Lte,
 }
 
 // CourseQueryParams for decoding (gorilla) query params into a struct for handling
@@ -49,7 +71,7 @@ type CourseQueryParams struct {
 	SecionInstructor   []string `json:"section-instructor" schema:"section-instructor" example:"exact:Rahman"`
 	SectionReqs        []string `json:"section-reqs" schema:"section-reqs"`
 	SectionStatus      []string `json:"section-status" schema:"section-status" example:"exact:Full"`
-	SectionCampus      []string `json:"section-campus" schema:"section-campus"	example:"exact:Main"`
+	SectionCampus      []string `json:"section-campus" schema:"section-campus" example:"exact:Main"`
 	SectionDelivery    []string `json:"section-delivery" schema:"section-delivery" example:"exact:Distance Studies/Online"`
 	SectionDay         []string `json:"section-time-day" schema:"section-time-day" example:"exact:M"`
 	SectionStartTime   []string `json:"section-time-start-time" schema:"section-time-start-time" example:"except:8:30 AM"`
@@ -61,32 +83,39 @@ type CourseQueryParams struct {
 	ClassName        []string `json:"course-name" schema:"course-name" example:"exact:INTRODUCTION TO PSYCHOLOGY"`
 	ClassDescription []string `json:"course-description" schema:"course-description"`
 
+	// FilterLevel decides if /courses returns only the matching sections of a course or all sections of a course with any match
+	FilterLevel string `json:"filter-level" schema:"filter-level" example:"course"`
+
 	SortBy string `json:"sortby" schema:"sortby" example:"sortby=course-number"`
 	Dec    bool   `json:"dec" schema:"dec" example:"true"`
 
-	Offset int `json:"offset" schema:"offset" example:"10"`
+	Offset int    `json:"offset" schema:"offset" example:"10"`
+	Limit  int    `json:"limit" schema:"limit" example:"5"`
+	Cursor string `json:"cursor" schema:"cursor"`
 
-	Limit int `json:"limit" schema:"limit" example:"5"`
+	Envelope bool `json:"envelope" schema:"envelope" example:"true"`
+
+	Fields []string `json:"fields" schema:"fields" example:"compact"`
+	Format string   `json:"format" schema:"format" example:"csv"`
 }
 
 // ExtractCourseFilter extracts course filters from request
-func ExtractCourseFilter(r *http.Request) (bson.M, error) {
+func ExtractCourseFilter(r *http.Request) (store.Filter, error) {
 
 	if r == nil {
-		return bson.M{}, errors.New("Request object is nil")
+		return store.Filter{}, errors.New("Request object is nil")
 	}
 
 	// Create struct to decode params into
 	params := new(CourseQueryParams)
 
 	if err := schema.NewDecoder().Decode(params, r.Form); err != nil {
-		fmt.Println("ExtractCourseFilter failed to decode request form into struct")
-		fmt.Println(err)
-		return bson.M{}, errors.New("Course query filters failed to decode")
+		logger.From(r.Context()).WithError(err).Warn("ExtractCourseFilter failed to decode request form into struct")
+		return store.Filter{}, errors.New("Course query filters failed to decode")
 	}
 
-	// Capture array of filters
-	filters := bson.A{}
+	// Capture filters, any of which has to match when inclusive
+	filter := store.Filter{Any: params.Inclusive}
 
 	// TODO: Let us ignore this monstrosity of code
 	for _, value := range params.SectionNumber {
@@ -97,13 +126,13 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 
 		num, err := strconv.Atoi(opValue)
 		if err != nil {
-			return bson.M{}, errors.New("Section number value failed to parse to integer")
+			return store.Filter{}, errors.New("Section number value failed to parse to integer")
 		}
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.number": bson.M{val: num}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.number", Op: val, Value: num})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section number command %d", op)
+			return store.Filter{}, fmt.Errorf("Invalid section number command %s", op)
 		}
 	}
 
@@ -114,9 +143,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.component": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.component", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section component command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section component command %s", op)
 		}
 	}
 
@@ -129,14 +158,14 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		num, err := strconv.Atoi(opValue)
 		if err != nil {
 			// handle error
-			fmt.Println(err)
+			logger.From(r.Context()).WithError(err).Warn("Invalid number filter")
 			num = 0
 		}
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.classNumber": bson.M{val: num}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.classNumber", Op: val, Value: num})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section course number  %d", op)
+			return store.Filter{}, fmt.Errorf("Invalid section course number command %s", op)
 		}
 	}
 
@@ -147,9 +176,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.location": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.location", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section location command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section location command %s", op)
 		}
 	}
 
@@ -160,9 +189,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.instructor": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.instructor", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section instructor command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section instructor command %s", op)
 		}
 	}
 
@@ -173,9 +202,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.requisites": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.requisites", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section requisites command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section requisites command %s", op)
 		}
 	}
 
@@ -186,9 +215,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.status": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.status", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section status command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section status command %s", op)
 		}
 	}
 
@@ -199,9 +228,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.campus": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.campus", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section campus command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section campus command %s", op)
 		}
 	}
 
@@ -212,9 +241,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.delivery": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.delivery", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section delivery command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section delivery command %s", op)
 		}
 	}
 
@@ -225,9 +254,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.times.days": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.times.days", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section days command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section days command %s", op)
 		}
 	}
 
@@ -238,9 +267,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.times.startTime": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.times.startTime", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section start time command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section start time command %s", op)
 		}
 	}
 
@@ -251,9 +280,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"sectionData.times.endTime": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "sectionData.times.endTime", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid section end time command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid section end time command %s", op)
 		}
 	}
 
@@ -264,9 +293,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"courseData.faculty": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "courseData.faculty", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid course faculty command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid course faculty command %s", op)
 		}
 	}
 
@@ -279,14 +308,14 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		num, err := strconv.Atoi(opValue)
 		if err != nil {
 			// handle error
-			fmt.Println(err)
+			logger.From(r.Context()).WithError(err).Warn("Invalid number filter")
 			num = 0
 		}
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"courseData.number": bson.M{val: num}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "courseData.number", Op: val, Value: num})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid course number command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid course number command %s", op)
 		}
 	}
 
@@ -297,9 +326,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"courseData.suffix": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "courseData.suffix", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid course suffix command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid course suffix command %s", op)
 		}
 	}
 
@@ -310,9 +339,9 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"courseData.name": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "courseData.name", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid course name command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid course name command %s", op)
 		}
 	}
 
@@ -323,39 +352,30 @@ func ExtractCourseFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"courseData.description": bson.M{val: opValue}})
-		} else {
-			return bson.M{}, fmt.Errorf("Invalid course description command %s", op)
-		}
-	}
-
-	if len(filters) > 0 {
-		if params.Inclusive == true {
-			return bson.M{"$or": filters}, nil
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "courseData.description", Op: val, Value: opValue})
 		} else {
-			return bson.M{"$and": filters}, nil
+			return store.Filter{}, fmt.Errorf("Invalid course description command %s", op)
 		}
 	}
 
-	return bson.M{}, nil
+	return filter, nil
 }
 
-// ExtractCourseParams extract extra params from request besides filters into a set of find options
-func ExtractCourseParams(r *http.Request) (*options.FindOptions, error) {
+// ExtractCourseParams extract extra params from request besides filters into the sort order and page to fetch
+func ExtractCourseParams(r *http.Request) (*Pagination, error) {
 
 	if r == nil {
-		return options.Find(), errors.New("Request object is nil")
+		return nil, errors.New("Request object is nil")
 	}
 
 	// Create struct to decode params into
 	params := new(CourseQueryParams)
 
 	if err := schema.NewDecoder().Decode(params, r.Form); err != nil {
-		return options.Find(), errors.New("Course query options failed to decode")
+		return nil, errors.New("Course query options failed to decode")
 	}
 
-	// Capture find options
-	result := options.Find()
+	sortParam := ""
 
 	// Determine sort parameters if they exist
 	if params.SortBy != "" {
@@ -363,11 +383,9 @@ func ExtractCourseParams(r *http.Request) (*options.FindOptions, error) {
 		sort := strings.Split(params.SortBy, "-")
 
 		if len(sort) < 2 || len(sort) > 4 {
-			return options.Find(), errors.New("Invalid sort criteria")
+			return nil, errors.New("Invalid sort criteria")
 		}
 
-		sortParam := ""
-
 		// TODO: FIX TIME SORTING
 		// Instead of model vs controller, create each as their own resource. better code organization
 
@@ -376,7 +394,7 @@ func ExtractCourseParams(r *http.Request) (*options.FindOptions, error) {
 		} else if sort[0] == "section" {
 			sortParam += "sectionData."
 		} else {
-			return options.Find(), errors.New("Invalid sort criteria")
+			return nil, errors.New("Invalid sort criteria")
 		}
 
 		index := 1
@@ -393,25 +411,21 @@ func ExtractCourseParams(r *http.Request) (*options.FindOptions, error) {
 				sortParam += strings.Title(strings.ToLower(sort[i]))
 			}
 		}
+	}
 
-		fmt.Println(sortParam)
+	result := NewPagination(sortParam, params.Dec)
 
-		// By default, sort ascending unless descending is specfied
-		if params.Dec == true {
-			result.SetSort(bson.D{{sortParam, -1}})
-		} else {
-			result.SetSort(bson.D{{sortParam, 1}})
-		}
+	// Sections have any number of meeting times, so neither sections nor courses sorted by them have a value to compare with
+	result.byOffset = strings.HasPrefix(sortParam, "sectionData.times.")
+
+	// Course results are large so only a page of them is returned by default
+	if params.Limit == 0 {
+		params.Limit = DefaultLimit
 	}
 
 	// Determine pagination parameters if they exist
-	if params.Limit != 0 {
-		result.SetLimit(int64(params.Limit))
-
-		// Can only create a skip in records if the limit is known
-		if params.Offset != 0 {
-			result.SetSkip(int64(params.Offset - 1))
-		}
+	if err := result.SetPage(params.Offset, params.Limit, params.Cursor, params.Envelope); err != nil {
+		return nil, err
 	}
 
 	return result, nil
@@ -426,27 +440,33 @@ type OptionQueryParams struct {
 	SortBy string `json:"sortby" schema:"sortby" example:"sortby=value"`
 	Dec    bool   `json:"dec" schema:"dec" example:"true"`
 
-	Offset int `json:"offset" schema:"offset" example:"10"`
-	Limit  int `json:"limit" schema:"limit" example:"5"`
+	Offset int    `json:"offset" schema:"offset" example:"10"`
+	Limit  int    `json:"limit" schema:"limit" example:"5"`
+	Cursor string `json:"cursor" schema:"cursor"`
+
+	Envelope bool `json:"envelope" schema:"envelope" example:"true"`
+
+	Fields []string `json:"fields" schema:"fields" example:"compact"`
+	Format string   `json:"format" schema:"format" example:"ndjson"`
 }
 
 // ExtractOptFilter extracts option filters from request
-func ExtractOptFilter(r *http.Request) (bson.M, error) {
+func ExtractOptFilter(r *http.Request) (store.Filter, error) {
 
 	if r == nil {
-		return bson.M{}, errors.New("Request object is nil")
+		return store.Filter{}, errors.New("Request object is nil")
 	}
 
 	// Create struct to decode params into
 	params := new(OptionQueryParams)
 
 	if err := schema.NewDecoder().Decode(params, r.Form); err != nil {
-		fmt.Println("ExtractOptFilter failed to decode request form into struct")
-		return bson.M{}, errors.New("Option query filters failed to decode")
+		logger.From(r.Context()).WithError(err).Warn("ExtractOptFilter failed to decode request form into struct")
+		return store.Filter{}, errors.New("Option query filters failed to decode")
 	}
 
-	// Capture array of filters
-	filters := bson.A{}
+	// Capture filters, any of which has to match when inclusive
+	filter := store.Filter{Any: params.Inclusive}
 
 	for _, value := range params.Value {
 		f := strings.Split(value, ":")
@@ -455,9 +475,9 @@ func ExtractOptFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"data.value": bson.M{val: opValue}})
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "data.value", Op: val, Value: opValue})
 		} else {
-			return bson.M{}, fmt.Errorf("Invalid filter command %s", op)
+			return store.Filter{}, fmt.Errorf("Invalid filter command %s", op)
 		}
 	}
 
@@ -468,58 +488,46 @@ func ExtractOptFilter(r *http.Request) (bson.M, error) {
 		opValue := f[1]
 
 		if val, ok := FilterToDBOp[op]; ok {
-			filters = append(filters, bson.M{"data.text": bson.M{val: opValue}})
-		} else {
-			return bson.M{}, fmt.Errorf("Invalid filter command %s", op)
-		}
-	}
-
-	if len(filters) > 0 {
-		if params.Inclusive == true {
-			return bson.M{"$or": filters}, nil
+			filter.Conditions = append(filter.Conditions, store.Condition{Field: "data.text", Op: val, Value: opValue})
 		} else {
-			return bson.M{"$and": filters}, nil
+			return store.Filter{}, fmt.Errorf("Invalid filter command %s", op)
 		}
 	}
 
-	return bson.M{}, nil
+	return filter, nil
 }
 
-// ExtractOptParams extract extra params from request besides filters into a set of find options
-func ExtractOptParams(r *http.Request) (*options.FindOptions, error) {
+// ExtractOptParams extract extra params from request besides filters into the sort order and page to fetch
+func ExtractOptParams(r *http.Request) (*Pagination, error) {
 
 	if r == nil {
-		return options.Find(), errors.New("Request object is nil")
+		return nil, errors.New("Request object is nil")
 	}
 
 	// Create struct to decode params into
 	params := new(OptionQueryParams)
 
 	if err := schema.NewDecoder().Decode(params, r.Form); err != nil {
-		return options.Find(), errors.New("Course query options failed to decode")
+		return nil, errors.New("Course query options failed to decode")
 	}
 
-	// Capture find options
-	result := options.Find()
+	sortParam := ""
 
 	// Determine sort parameters if they exist
 	if params.SortBy != "" {
-		// By default, sort ascending unless descending is specfied
-		if params.Dec == true {
-			result.SetSort(bson.D{{"data." + params.SortBy, -1}})
-		} else {
-			result.SetSort(bson.D{{"data." + params.SortBy, 1}})
-		}
+		sortParam = "data." + params.SortBy
 	}
 
-	// Determine pagination parameters if they exist
-	if params.Limit != 0 {
-		result.SetLimit(int64(params.Limit))
+	result := NewPagination(sortParam, params.Dec)
 
-		// Can only create a skip in records if the limit is known
-		if params.Offset != 0 {
-			result.SetSkip(int64(params.Offset - 1))
-		}
+	// Every option of a form fits in a single page
+	if params.Limit == 0 {
+		params.Limit = MaxLimit
+	}
+
+	// Determine pagination parameters if they exist
+	if err := result.SetPage(params.Offset, params.Limit, params.Cursor, params.Envelope); err != nil {
+		return nil, err
 	}
 
 	return result, nil
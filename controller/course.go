@@ -1,191 +1,485 @@
 package controller
 
 import (
-	"context"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
 	"uwo-tt-api/model"
+	"uwo-tt-api/store"
 )
 
+// courseCode matches a course number with an optional suffix; 1026 or 1026B
+var courseCode = regexp.MustCompile(`^([0-9]+)([A-Za-z]?)$`)
+
+// ParseCourseCode splits a course code such as 2210A into its number and suffix
+func ParseCourseCode(code string) (int, string, error) {
+	match := courseCode.FindStringSubmatch(code)
+	if match == nil {
+		return 0, "", fmt.Errorf("Invalid course code %s", code)
+	}
+
+	number, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, "", fmt.Errorf("Invalid course code %s", code)
+	}
+
+	return number, strings.ToUpper(match[2]), nil
+}
+
 // ListSections godoc
 // @Summary List all sections
 // @Description Grabs each individual model.Section that matches query filters and options
 // @Tags course
 // @ID courses-list-sections
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param test query CourseQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Section
+// @Header 200 {integer} X-Total-Count "Number of sections matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /sections [get]
 func (c *Controller) ListSections(w http.ResponseWriter, r *http.Request) {
-	HitEndpoint("courses")
+	HitEndpoint(r, "courses")
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 
-	// Connect to courses collection
-	collection := c.DB.Collection("courses")
-
 	// Check if url can be parsed
 	if err := r.ParseForm(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to parse course query parameters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse course query parameters")
 		return
 	}
 
 	// Extract find filters
 	findFilter, err := ExtractCourseFilter(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract course filters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course filters")
 		return
 	}
 
-	// Extract find options
-	findOptions, err := ExtractCourseParams(r)
+	// Extract sort and page
+	page, err := ExtractCourseParams(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract course options")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course options")
 		return
 	}
 
-	// Perform DB query
-	cur, err := collection.Find(context.TODO(), findFilter, findOptions)
+	fields, err := SectionFields.Extract(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract section fields")
 		return
 	}
 
-	// Define an array to store the decoded documents
-	var sections []model.Section
+	format, err := NegotiateFormat(r, true)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to negotiate section format")
+		return
+	}
+
+	// Tables have fixed columns so every field is needed
+	if format == CSVFormat || format == XLSXFormat {
+		fields = Fields{}
+	}
+
+	// Every query of the page reads the same generation, even if another is published meanwhile
+	snapshot, err := c.Repo.Snapshot(r.Context())
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to read the live generation")
+		return
+	}
+
+	// Count every matching section for the page totals
+	total, err := snapshot.CountSections(r.Context(), findFilter)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
+
+	// Find the boundaries of the page from the sort keys of its sections
+	keys, err := snapshot.SectionKeys(r.Context(), page.Query(findFilter))
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
+
+	keys, next, prev, err := page.Trim(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to create page cursors")
+		return
+	}
 
-	for cur.Next(context.TODO()) {
-		//Create a value into which the single document can be decoded
-		var elem model.Section
-		err := cur.Decode(&elem)
+	window, err := page.Window(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to create page window")
+		return
+	}
+
+	// Stream the sections of the page straight from the store
+	var stream store.Cursor
+	if len(keys) > 0 {
+		query := window.Query(findFilter)
+		query.Fields = fields.Projection()
+
+		stream, err = snapshot.FindSections(r.Context(), query)
 		if err != nil {
-			w = NewError(w, http.StatusBadRequest, err, "Failed to decode db result")
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
 			return
 		}
-
-		sections = append(sections, elem)
 	}
 
-	if err := cur.Err(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to iterate over db results")
-		return
+	result := &PageStream{
+		Page:   page,
+		Total:  total,
+		Count:  len(keys),
+		Next:   next,
+		Prev:   prev,
+		Cursor: stream,
+		Fields: fields,
+		Format: format,
+		New:    func() interface{} { return new(model.Section) },
 	}
 
-	//Close the cursor once finished
-	cur.Close(context.TODO())
+	result.Write(w, r)
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(sections)
+// Filter levels of the courses endpoint
+const (
+	// SectionLevel returns only the sections of a course that match the filters
+	SectionLevel = "section"
+	// CourseLevel returns every section of a course that has at least one section matching the filters
+	CourseLevel = "course"
+)
+
+// CourseQuery builds the store query of a page of courses grouped from the sections matching a filter, at a filter level
+func CourseQuery(filter store.Filter, page *Pagination, level string) (store.CourseQuery, error) {
+	q := store.CourseQuery{Query: page.Query(store.Filter{}), Sections: filter}
+
+	// Courses sorted descending by a section field are sorted by their highest section, even when paging backwards
+	q.Highest = page.Dir == -1
+
+	switch level {
+	case "", SectionLevel:
+	case CourseLevel:
+		q.AllSections = true
+	default:
+		return q, fmt.Errorf("Invalid filter level %s", level)
+	}
+
+	return q, nil
 }
 
 // ListCourses godoc
 // @Summary List all courses
-// @Description Grabs each individual section that matches query filters and options and combines them into a course struct for all sections that have matching course info
+// @Description Groups each individual section that matches query filters into a course struct for all sections that have matching course info. Sorting and pagination apply to courses, not sections. With filter-level=course, every section of a matching course is returned
 // @Tags course
 // @ID courses-list-courses
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson,text/csv,application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param test query CourseQueryParams false "Course filter, sort, pagination"
-// @Success 200 {array} model.Section
+// @Success 200 {array} model.Course
+// @Header 200 {integer} X-Total-Count "Number of courses matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /courses [get]
 func (c *Controller) ListCourses(w http.ResponseWriter, r *http.Request) {
-	HitEndpoint("courses")
+	HitEndpoint(r, "courses")
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 
-	// Connect to courses collection
-	collection := c.DB.Collection("courses")
-
 	// Check if url can be parsed
 	if err := r.ParseForm(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to parse course query parameters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse course query parameters")
 		return
 	}
 
 	// Extract find filters
 	findFilter, err := ExtractCourseFilter(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract course filters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course filters")
 		return
 	}
 
-	// Extract find options
-	findOptions, err := ExtractCourseParams(r)
+	// Extract sort and page
+	page, err := ExtractCourseParams(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract course options")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course options")
 		return
 	}
 
-	// Perform DB query
-	cur, err := collection.Find(context.TODO(), findFilter, findOptions)
+	fields, err := CourseFields.Extract(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course fields")
 		return
 	}
 
-	// Define an array to store the decoded documents
-	var sections []model.Section
+	format, err := NegotiateFormat(r, true)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to negotiate course format")
+		return
+	}
+
+	// Tables have fixed columns so every field is needed
+	if format == CSVFormat || format == XLSXFormat {
+		fields = Fields{}
+	}
+
+	level := r.Form.Get("filter-level")
+
+	query, err := CourseQuery(findFilter, page, level)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course options")
+		return
+	}
+
+	// Every query of the page reads the same generation, even if another is published meanwhile
+	snapshot, err := c.Repo.Snapshot(r.Context())
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to read the live generation")
+		return
+	}
+
+	// Count every matching course for the page totals
+	total, err := snapshot.CountCourses(r.Context(), findFilter)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
+
+	// Find the boundaries of the page from the sort keys of its courses
+	keys, err := snapshot.CourseKeys(r.Context(), query)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
+
+	keys, next, prev, err := page.Trim(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to create page cursors")
+		return
+	}
 
-	for cur.Next(context.TODO()) {
-		//Create a value into which the single document can be decoded
-		var elem model.Section
-		err := cur.Decode(&elem)
+	window, err := page.Window(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to create page window")
+		return
+	}
+
+	// Stream the courses of the page straight from the store
+	var stream store.Cursor
+	if len(keys) > 0 {
+		// The level was already checked with the page's query
+		query, _ = CourseQuery(findFilter, window, level)
+		query.Fields = fields.Projection()
+
+		stream, err = snapshot.FindCourses(r.Context(), query)
 		if err != nil {
-			w = NewError(w, http.StatusBadRequest, err, "Failed to decode db result")
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
 			return
 		}
+	}
 
-		sections = append(sections, elem)
+	result := &PageStream{
+		Page:   page,
+		Total:  total,
+		Count:  len(keys),
+		Next:   next,
+		Prev:   prev,
+		Cursor: stream,
+		Fields: fields,
+		Format: format,
+		New:    func() interface{} { return new(model.Course) },
 	}
 
-	// Create list of courses
-	var courses []model.Course
+	result.Write(w, r)
+}
 
-	// Combine sections into SectioData slice in course
-	for _, section := range sections {
-		if len(courses) == 0 {
-			// If the first course, simply copy section data into course and append
-			var course model.Course
-			course.Source = section.Source
-			course.Time = section.Time
-			course.CourseData = section.CourseData
-			course.SectionData = append(course.SectionData, section.SectionData)
-
-			courses = append(courses, course)
-		} else {
+// GetCourse godoc
+// @Summary Get a single course
+// @Description Grabs every section of the course identified by its subject and code and combines them into a single course
+// @Tags course
+// @ID courses-get-course
+// @Accept plain
+// @Produce json
+// @Param subject path string true "Course subject" example(COMPSCI)
+// @Param number path string true "Course number with optional suffix" example(2210A)
+// @Param fields query string false "Comma separated fields or presets (compact, full) to return" example(compact)
+// @Success 200 {object} model.Course
+// @Failure 400 {object} HTTPError
+// @Failure 404 {object} HTTPError
+// @Router /courses/{subject}/{number} [get]
+func (c *Controller) GetCourse(w http.ResponseWriter, r *http.Request) {
+	HitEndpoint(r, "course")
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+
+	// Check if url can be parsed
+	if err := r.ParseForm(); err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse course query parameters")
+		return
+	}
+
+	fields, err := CourseFields.Extract(r)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract course fields")
+		return
+	}
+
+	subject := strings.ToUpper(PathParam(r, "subject"))
+
+	number, suffix, err := ParseCourseCode(PathParam(r, "number"))
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse course code")
+		return
+	}
 
-			// Get last course in list
-			last := courses[len(courses)-1]
-
-			if last.CourseData == section.CourseData {
-				// If the last course in the list matches the section data, append section data
-				courses[len(courses)-1].SectionData = append(courses[len(courses)-1].SectionData, section.SectionData)
-			} else {
-				// If the last course in the list does not match the section data, create a new course in the list
-				var course model.Course
-				course.Source = section.Source
-				course.Time = section.Time
-				course.CourseData = section.CourseData
-				course.SectionData = append(course.SectionData, section.SectionData)
-
-				courses = append(courses, course)
-			}
+	findFilter := store.Filter{Conditions: []store.Condition{
+		{Field: "courseData.faculty", Op: store.Eq, Value: subject},
+		{Field: "courseData.number", Op: store.Eq, Value: number},
+		{Field: "courseData.suffix", Op: store.Eq, Value: suffix},
+	}}
+
+	// Keep sections in a predictable order
+	query := store.Query{
+		Filter: findFilter,
+		Sort:   []string{"sectionData.number", "sectionData.component"},
+		Fields: fields.Projection(),
+	}
+
+	// Perform DB query
+	cur, err := c.Repo.FindSections(r.Context(), query)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
+
+	defer cur.Close(r.Context())
+
+	var sections []model.Section
+	for cur.Next(r.Context()) {
+		var section model.Section
+		if err := cur.Decode(&section); err != nil {
+			w = NewError(w, r, http.StatusBadRequest, err, "Failed to decode db result")
+			return
 		}
+
+		sections = append(sections, section)
 	}
 
 	if err := cur.Err(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to iterate over db results")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to iterate over db results")
+		return
+	}
+
+	if len(sections) == 0 {
+		err := fmt.Errorf("Course %s %d%s does not exist", subject, number, suffix)
+		w = NewError(w, r, http.StatusNotFound, err, "Course not found")
+		return
+	}
+
+	// Every section shares the same course information
+	course := model.Course{
+		Source:     sections[0].Source,
+		Time:       sections[0].Time,
+		CourseData: sections[0].CourseData,
+	}
+
+	for _, section := range sections {
+		course.SectionData = append(course.SectionData, section.SectionData)
+	}
+
+	data, err := fields.Marshal(course)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to encode course")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// GetSection godoc
+// @Summary Get a single section
+// @Description Grabs the model.Section identified by its class number
+// @Tags course
+// @ID courses-get-section
+// @Accept plain
+// @Produce json
+// @Param classNumber path int true "Section class number" example(5000)
+// @Param fields query string false "Comma separated fields or presets (compact, full) to return" example(compact)
+// @Success 200 {object} model.Section
+// @Failure 400 {object} HTTPError
+// @Failure 404 {object} HTTPError
+// @Router /sections/{classNumber} [get]
+func (c *Controller) GetSection(w http.ResponseWriter, r *http.Request) {
+	HitEndpoint(r, "section")
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+
+	// Check if url can be parsed
+	if err := r.ParseForm(); err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse section query parameters")
+		return
+	}
+
+	fields, err := SectionFields.Extract(r)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract section fields")
+		return
+	}
+
+	classNumber, err := strconv.Atoi(PathParam(r, "classNumber"))
+	if err != nil {
+		err = fmt.Errorf("Invalid class number %s", PathParam(r, "classNumber"))
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse class number")
+		return
+	}
+
+	query := store.Query{
+		Filter: store.Filter{Conditions: []store.Condition{
+			{Field: "sectionData.classNumber", Op: store.Eq, Value: classNumber},
+		}},
+		Limit:  1,
+		Fields: fields.Projection(),
+	}
+
+	cur, err := c.Repo.FindSections(r.Context(), query)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+		return
+	}
+
+	defer cur.Close(r.Context())
+
+	if !cur.Next(r.Context()) {
+		err := cur.Err()
+		if err == nil {
+			err = fmt.Errorf("Section %d does not exist", classNumber)
+			w = NewError(w, r, http.StatusNotFound, err, "Section not found")
+		} else {
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+		}
+
 		return
 	}
 
-	//Close the cursor once finished
-	cur.Close(context.TODO())
+	var section model.Section
+	if err := cur.Decode(&section); err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to decode db result")
+		return
+	}
+
+	data, err := fields.Marshal(section)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to encode section")
+		return
+	}
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(courses)
+	w.Write(data)
 }
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"testing"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+)
+
+// newTestController serves sections of a few courses from memory, told apart by class number
+func newTestController(t *testing.T) *Controller {
+	ctx := context.Background()
+	repo := store.NewMemory()
+
+	section := func(faculty string, number int, component string, classNumber int, days ...string) model.Section {
+		times := []model.TimeComponent{}
+		for _, day := range days {
+			times = append(times, model.TimeComponent{Day: day, StartTime: "9:30 AM", EndTime: "10:30 AM"})
+		}
+
+		return model.Section{
+			ID:          fmt.Sprintf("test-%d", classNumber),
+			CourseData:  model.CourseComponent{Faculty: faculty, Number: number},
+			SectionData: model.SectionComponent{Number: classNumber % 10, Component: component, ClassNumber: classNumber, Times: times},
+		}
+	}
+
+	sections := []model.Section{
+		section("CS", 2210, "LEC", 11, "M"),
+		section("CS", 2210, "LAB", 17, "W"),
+		section("CS", 1026, "LEC", 12, "T"),
+		section("MATH", 1600, "LEC", 13, "M", "W"),
+		section("MATH", 1600, "TUT", 15, "F"),
+		section("MATH", 1600, "TUT", 14, "F"),
+		section("BIO", 1001, "LEC", 16),
+	}
+
+	gen, err := repo.NewGeneration(ctx, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.WriteSections(ctx, gen.Number, sections); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.PublishGeneration(ctx, gen.Number); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Controller{Repo: repo}
+}
+
+// linkCursor matches the cursor of a link
+var linkCursor = regexp.MustCompile(`cursor=([^&]+)`)
+
+// linkRel matches a link of the Link header by its relation
+func linkRel(rel string) *regexp.Regexp {
+	return regexp.MustCompile(`<([^>]+)>; rel="` + rel + `"`)
+}
+
+// fetchPage requests a page, returning the results read by decode and the links to the adjacent pages
+func fetchPage(t *testing.T, handler http.HandlerFunc, url string, decode func(body []byte) ([]string, error)) (results []string, total string, next string, prev string) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, url, nil))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s answered %d: %s", url, w.Code, w.Body)
+	}
+
+	results, err := decode(w.Body.Bytes())
+	if err != nil {
+		t.Fatalf("%s answered %s: %v", url, w.Body, err)
+	}
+
+	link := w.Header().Get("Link")
+	if match := linkRel("next").FindStringSubmatch(link); match != nil {
+		next = match[1]
+	}
+
+	if match := linkRel("prev").FindStringSubmatch(link); match != nil {
+		prev = match[1]
+	}
+
+	return results, w.Header().Get("X-Total-Count"), next, prev
+}
+
+// pageThrough follows the next links from the first page, then the prev links back from the last, checking both find the same pages
+func pageThrough(t *testing.T, handler http.HandlerFunc, url string, decode func(body []byte) ([]string, error)) ([]string, string) {
+	pages := [][]string{}
+	var total, prev string
+
+	for link := url; link != ""; {
+		var results []string
+
+		results, total, link, prev = fetchPage(t, handler, link, decode)
+		pages = append(pages, results)
+
+		if len(pages) > 1 && prev == "" {
+			t.Fatalf("page %d of %s has no prev link", len(pages), url)
+		}
+
+		if len(pages) > 20 {
+			t.Fatalf("%s never ends", url)
+		}
+	}
+
+	for i := len(pages) - 2; i >= 0; i-- {
+		var results []string
+
+		results, _, _, prev = fetchPage(t, handler, prev, decode)
+		if !reflect.DeepEqual(results, pages[i]) {
+			t.Errorf("paging back found %v, want %v", results, pages[i])
+		}
+	}
+
+	if prev != "" {
+		t.Errorf("first page found paging back has a prev link")
+	}
+
+	all := []string{}
+	for _, page := range pages {
+		all = append(all, page...)
+	}
+
+	return all, total
+}
+
+func decodeSections(body []byte) ([]string, error) {
+	var sections []model.Section
+	if err := json.Unmarshal(body, &sections); err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, section := range sections {
+		result = append(result, strconv.Itoa(section.SectionData.ClassNumber))
+	}
+
+	return result, nil
+}
+
+func decodeCourses(body []byte) ([]string, error) {
+	var courses []model.Course
+	if err := json.Unmarshal(body, &courses); err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	for _, course := range courses {
+		classNumbers := ""
+		for _, section := range course.SectionData {
+			classNumbers += fmt.Sprintf(" %d", section.ClassNumber)
+		}
+
+		result = append(result, fmt.Sprintf("%s %d:%s", course.CourseData.Faculty, course.CourseData.Number, classNumbers))
+	}
+
+	return result, nil
+}
+
+func TestListSectionsPages(t *testing.T) {
+	c := newTestController(t)
+
+	tests := []struct {
+		url   string
+		total string
+		want  []string
+	}{
+		{"/sections?limit=2", "7", []string{"11", "17", "12", "13", "15", "14", "16"}},
+		{"/sections?limit=3&sortby=section-class-number", "7", []string{"11", "12", "13", "14", "15", "16", "17"}},
+		{"/sections?limit=2&sortby=section-class-number&dec=true", "7", []string{"17", "16", "15", "14", "13", "12", "11"}},
+		{"/sections?limit=2&sortby=course-number", "7", []string{"16", "12", "13", "15", "14", "11", "17"}},
+		{"/sections?limit=1&sortby=course-faculty&course-faculty=except:MATH", "4", []string{"16", "11", "17", "12"}},
+		{"/sections?limit=10", "7", []string{"11", "17", "12", "13", "15", "14", "16"}},
+		// Meeting times are arrays, so their pages are found by offset
+		{"/sections?limit=2&sortby=section-time-days", "7", []string{"16", "15", "14", "11", "13", "12", "17"}},
+		{"/sections?limit=3&sortby=section-time-days&dec=true&course-faculty=exact:CS", "3", []string{"17", "12", "11"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, total := pageThrough(t, c.ListSections, test.url, decodeSections)
+
+			if total != test.total {
+				t.Errorf("total is %s, want %s", total, test.total)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("paged %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListCoursesPages(t *testing.T) {
+	c := newTestController(t)
+
+	tests := []struct {
+		url   string
+		total string
+		want  []string
+	}{
+		// Courses are identified, and sorted by default, by their faculty, number and suffix
+		{"/courses?limit=1", "4", []string{"BIO 1001: 16", "CS 1026: 12", "CS 2210: 11 17", "MATH 1600: 13 15 14"}},
+		{"/courses?limit=2&sortby=course-number", "4", []string{"BIO 1001: 16", "CS 1026: 12", "MATH 1600: 13 15 14", "CS 2210: 11 17"}},
+		// Courses are sorted by their lowest section, or their highest when descending
+		{"/courses?limit=1&sortby=section-class-number", "4", []string{"CS 2210: 11 17", "CS 1026: 12", "MATH 1600: 13 15 14", "BIO 1001: 16"}},
+		{"/courses?limit=3&sortby=section-class-number&dec=true", "4", []string{"CS 2210: 11 17", "BIO 1001: 16", "MATH 1600: 13 15 14", "CS 1026: 12"}},
+		{"/courses?limit=2&sortby=section-time-days", "4", []string{"BIO 1001: 16", "MATH 1600: 13 15 14", "CS 2210: 11 17", "CS 1026: 12"}},
+		{"/courses?limit=1&section-component=exact:TUT", "1", []string{"MATH 1600: 15 14"}},
+		{"/courses?limit=1&section-component=exact:LAB&filter-level=course", "1", []string{"CS 2210: 11 17"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, total := pageThrough(t, c.ListCourses, test.url, decodeCourses)
+
+			if total != test.total {
+				t.Errorf("total is %s, want %s", total, test.total)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("paged %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestListSectionsRejectsKeysetCursorOfTimes(t *testing.T) {
+	c := newTestController(t)
+
+	// A cursor of a page sorted by class number cannot continue a sort by meeting times, which is paged by offset
+	_, _, next, _ := fetchPage(t, c.ListSections, "/sections?limit=2&sortby=section-class-number", decodeSections)
+	cursor := linkCursor.FindStringSubmatch(next)[1]
+
+	w := httptest.NewRecorder()
+	c.ListSections(w, httptest.NewRequest(http.MethodGet, "/sections?limit=2&sortby=section-time-days&cursor="+cursor, nil))
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("answered %d, want %d", w.Code, http.StatusBadRequest)
+	}
+}
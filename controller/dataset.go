@@ -0,0 +1,64 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"uwo-tt-api/dataset"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/store"
+)
+
+// GetLatestDataset godoc
+// @Summary Download the latest dataset
+// @Description Downloads every option and section published by the latest scrape run as a gzipped, versioned JSON model.Dataset. The ETag of the download is the id of the run
+// @Tags dataset
+// @ID dataset-latest
+// @Accept plain
+// @Produce application/gzip
+// @Success 200 {object} model.Dataset
+// @Success 304
+// @Failure 404 {object} HTTPError
+// @Header 200 {string} ETag "Id of the scrape run that published the dataset"
+// @Router /dataset/latest [get]
+func (c *Controller) GetLatestDataset(w http.ResponseWriter, r *http.Request) {
+	HitEndpoint(r, "dataset")
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+
+	// The dataset is read from the generation its run wrote, even if another is published meanwhile
+	snapshot, err := c.Repo.Snapshot(r.Context())
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to read the live generation")
+		return
+	}
+
+	run, err := snapshot.Run(r.Context())
+	if errors.Is(err, store.ErrNotFound) {
+		err := errors.New("No dataset has been published yet")
+		w = NewError(w, r, http.StatusNotFound, err, "Dataset not found")
+		return
+	} else if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+		return
+	}
+
+	// A dataset never changes once its run is published
+	etag := `"` + run.ID + `"`
+	w.Header().Set("ETag", etag)
+
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="uwo-tt-api-%s.json.gz"`, run.ID))
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent so a failure can only be logged
+	if err := dataset.Export(r.Context(), snapshot, run, w); err != nil {
+		logger.From(r.Context()).WithError(err).Error("Failed to export dataset")
+	}
+}
@@ -2,8 +2,10 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
+	"uwo-tt-api/logger"
+
+	"github.com/sirupsen/logrus"
 )
 
 // HTTPError encapsulate http error fields
@@ -12,9 +14,14 @@ type HTTPError struct {
 	Message string `json:"message" example:"status bad request"`
 }
 
-// NewError create a new http error and print message for debugging
-func NewError(w http.ResponseWriter, status int, err error, logMsg string) http.ResponseWriter {
-	fmt.Println(logMsg)
+// NewError create a new http error and log message for debugging, as an error if the server failed
+func NewError(w http.ResponseWriter, r *http.Request, status int, err error, logMsg string) http.ResponseWriter {
+	entry := logger.From(r.Context()).WithFields(logrus.Fields{"status": status, "error": err.Error()})
+	if status >= http.StatusInternalServerError {
+		entry.Error(logMsg)
+	} else {
+		entry.Warn(logMsg)
+	}
 
 	er := HTTPError{
 		Code:    status,
@@ -0,0 +1,222 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"uwo-tt-api/model"
+)
+
+// FullPreset selects every field of a result
+const FullPreset = "full"
+
+// FieldSet lists the fields a client can select for a type of result, and named presets of fields
+type FieldSet struct {
+	paths   map[string]bool
+	presets map[string][]string
+}
+
+// Fields is the set of fields selected by a client. An empty set selects every field
+type Fields struct {
+	tree fieldTree
+}
+
+// fieldTree nests selected field names by path; a field without children is selected entirely
+type fieldTree map[string]fieldTree
+
+// sectionCompact fields of a course section needed to build a timetable
+var sectionCompact = []string{
+	"courseData.faculty",
+	"courseData.number",
+	"courseData.suffix",
+	"courseData.name",
+	"sectionData.number",
+	"sectionData.component",
+	"sectionData.classNumber",
+	"sectionData.location",
+	"sectionData.instructor",
+	"sectionData.status",
+	"sectionData.times",
+}
+
+// SectionFields selectable fields of model.Section results
+var SectionFields = NewFieldSet(model.Section{}, map[string][]string{"compact": sectionCompact})
+
+// CourseFields selectable fields of model.Course results
+var CourseFields = NewFieldSet(model.Course{}, map[string][]string{"compact": sectionCompact})
+
+// OptionFields selectable fields of model.Option results
+var OptionFields = NewFieldSet(model.Option{}, map[string][]string{"compact": {"data"}})
+
+// NewFieldSet creates a field set from every field of a model, named by the dotted path of its bson tags
+func NewFieldSet(v interface{}, presets map[string][]string) *FieldSet {
+	set := &FieldSet{paths: map[string]bool{}, presets: presets}
+	set.addPaths(reflect.TypeOf(v), "")
+
+	return set
+}
+
+// addPaths adds the path of every field of a struct type, including nested structs and slices of structs
+func (set *FieldSet) addPaths(t reflect.Type, prefix string) {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || t == reflect.TypeOf(time.Time{}) {
+		return
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		set.paths[prefix+name] = true
+		set.addPaths(t.Field(i).Type, prefix+name+".")
+	}
+}
+
+// Extract reads the comma separated fields and presets of the fields query parameter
+func (set *FieldSet) Extract(r *http.Request) (Fields, error) {
+	fields := Fields{}
+
+	if r == nil {
+		return fields, nil
+	}
+
+	paths := []string{}
+	for _, value := range r.Form["fields"] {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+
+			if field == "" {
+				continue
+			} else if field == FullPreset {
+				// Every field is selected so nothing else matters
+				return Fields{}, nil
+			} else if preset, ok := set.presets[field]; ok {
+				paths = append(paths, preset...)
+			} else if set.paths[field] {
+				paths = append(paths, field)
+			} else {
+				return fields, fmt.Errorf("Invalid field %s", field)
+			}
+		}
+	}
+
+	if len(paths) == 0 {
+		return fields, nil
+	}
+
+	// Selecting a field selects all of its children so shorter paths go first
+	sort.Strings(paths)
+
+	fields.tree = fieldTree{}
+	for _, path := range paths {
+		fields.tree.add(strings.Split(path, "."))
+	}
+
+	return fields, nil
+}
+
+// add selects the field at a path unless a parent of it is already selected
+func (tree fieldTree) add(path []string) {
+	child, ok := tree[path[0]]
+
+	if len(path) == 1 {
+		tree[path[0]] = nil
+		return
+	}
+
+	if ok && child == nil {
+		return
+	}
+
+	if !ok {
+		child = fieldTree{}
+		tree[path[0]] = child
+	}
+
+	child.add(path[1:])
+}
+
+// All reports if every field is selected
+func (fields Fields) All() bool {
+	return len(fields.tree) == 0
+}
+
+// Projection returns the dotted paths of the selected fields, or nil if every field is selected
+func (fields Fields) Projection() []string {
+	if fields.All() {
+		return nil
+	}
+
+	projection := []string{}
+	fields.tree.project(&projection, "")
+
+	return projection
+}
+
+// project adds every selected path to a projection
+func (tree fieldTree) project(projection *[]string, prefix string) {
+	for name, child := range tree {
+		if child == nil {
+			*projection = append(*projection, prefix+name)
+		} else {
+			child.project(projection, prefix+name+".")
+		}
+	}
+}
+
+// Marshal encodes a result as JSON with only the selected fields
+func (fields Fields) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || fields.All() {
+		return data, err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(fields.tree.prune(generic))
+}
+
+// prune removes every field of a decoded JSON value that is not selected
+func (tree fieldTree) prune(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		result := map[string]interface{}{}
+
+		for name, child := range tree {
+			field, ok := value[name]
+			if !ok {
+				continue
+			}
+
+			if child == nil {
+				result[name] = field
+			} else {
+				result[name] = child.prune(field)
+			}
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+
+		for i := range value {
+			result[i] = tree.prune(value[i])
+		}
+
+		return result
+	default:
+		return v
+	}
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+)
+
+// Health reports if the server is up, and for readiness the result of each check
+type Health struct {
+	Status string            `json:"status" example:"ok"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Freshness reports when the served data was published and if it is older than the server allows
+type Freshness struct {
+	Generation int64  `json:"generation" example:"12"`
+	Run        string `json:"run" example:"5ef3b1c2a4d2f1a2b3c4d5e6"`
+	// MaxAge is the age in seconds after which the data is stale
+	MaxAge      int64                 `json:"maxAge" example:"172800"`
+	Stale       bool                  `json:"stale" example:"false"`
+	Collections []CollectionFreshness `json:"collections"`
+}
+
+// CollectionFreshness reports when a collection was last published
+type CollectionFreshness struct {
+	Name      string    `json:"name" example:"subjects"`
+	Published time.Time `json:"published"`
+	// Age is the time in seconds since the collection was published
+	Age       int64 `json:"age" example:"3600"`
+	Documents int64 `json:"documents" example:"120"`
+	Stale     bool  `json:"stale" example:"false"`
+}
+
+// Healthz reports that the server is running, for liveness probes
+func (c *Controller) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Health{Status: "ok"})
+}
+
+// Readyz reports if the server can serve data, for readiness probes: the database must be reachable and hold courses
+func (c *Controller) Readyz(w http.ResponseWriter, r *http.Request) {
+	health := Health{Status: "ready", Checks: map[string]string{"database": "ok", "courses": "ok"}}
+
+	if err := c.Repo.Ping(r.Context()); err != nil {
+		health.Checks["database"] = err.Error()
+		health.Checks["courses"] = "not checked"
+	} else if count, err := c.Repo.CountSections(r.Context(), store.Filter{}); err != nil {
+		health.Checks["courses"] = err.Error()
+	} else if count == 0 {
+		health.Checks["courses"] = "none published yet"
+	}
+
+	status := http.StatusOK
+	if health.Checks["database"] != "ok" || health.Checks["courses"] != "ok" {
+		health.Status = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(health)
+}
+
+// GetFreshness godoc
+// @Summary Data freshness
+// @Description Reports when each collection was last published by a scrape, how many documents it holds and if it is older than the server allows
+// @Tags freshness
+// @ID freshness
+// @Accept plain
+// @Produce json
+// @Success 200 {object} Freshness
+// @Failure 400 {object} HTTPError
+// @Failure 404 {object} HTTPError
+// @Router /freshness [get]
+func (c *Controller) GetFreshness(w http.ResponseWriter, r *http.Request) {
+	HitEndpoint(r, "freshness")
+
+	// Set response headers
+	w.Header().Set("Content-Type", "application/json")
+
+	snapshot, err := c.Repo.Snapshot(r.Context())
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to read the live generation")
+		return
+	}
+
+	run, err := snapshot.Run(r.Context())
+	if errors.Is(err, store.ErrNotFound) {
+		w = NewError(w, r, http.StatusNotFound, errors.New("No data has been published yet"), "Freshness not found")
+		return
+	} else if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+		return
+	}
+
+	gen, err := store.FindGeneration(r.Context(), c.Repo, run.Generation)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+		return
+	}
+
+	freshness := Freshness{
+		Generation:  gen.Number,
+		Run:         run.ID,
+		MaxAge:      int64(c.MaxAge.Seconds()),
+		Collections: []CollectionFreshness{},
+	}
+
+	// Every collection is counted in the generation of the run, even if another is published meanwhile
+	for _, name := range append(append([]string{}, model.OptionCollections...), "courses") {
+		var count int64
+		if name == "courses" {
+			count, err = snapshot.CountSections(r.Context(), store.Filter{})
+		} else {
+			count, err = snapshot.CountOptions(r.Context(), name, store.Filter{})
+		}
+
+		if err != nil {
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed")
+			return
+		}
+
+		age := time.Since(gen.Published)
+		collection := CollectionFreshness{
+			Name:      name,
+			Published: gen.Published,
+			Age:       int64(age.Seconds()),
+			Documents: count,
+			Stale:     c.MaxAge > 0 && age > c.MaxAge,
+		}
+
+		freshness.Stale = freshness.Stale || collection.Stale
+		freshness.Collections = append(freshness.Collections, collection)
+	}
+
+	json.NewEncoder(w).Encode(freshness)
+}
@@ -1,72 +1,110 @@
 package controller
 
 import (
-	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
+	"uwo-tt-api/logger"
 	"uwo-tt-api/model"
+	"uwo-tt-api/store"
 )
 
 func (c *Controller) optionsEndpoint(collectionName string, w http.ResponseWriter, r *http.Request) {
-	HitEndpoint("courses")
+	HitEndpoint(r, collectionName)
 
 	// Set response headers
 	w.Header().Set("Content-Type", "application/json")
 
-	// Connect to collection
-	collection := c.DB.Collection(collectionName)
-
 	// Check if url can be parsed
 	if err := r.ParseForm(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to parse option query parameters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to parse option query parameters")
 		return
 	}
 
 	// Extract find filters
 	findFilter, err := ExtractOptFilter(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract option filters")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract option filters")
 		return
 	}
 
-	findOptions, err := ExtractOptParams(r)
+	page, err := ExtractOptParams(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to extract option options")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract option options")
 		return
 	}
 
-	cur, err := collection.Find(context.TODO(), findFilter, findOptions)
+	fields, err := OptionFields.Extract(r)
 	if err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to extract option fields")
 		return
 	}
 
-	//Define an array in which you can store the decoded documents
-	var options []model.Option
+	format, err := NegotiateFormat(r, false)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to negotiate option format")
+		return
+	}
 
-	for cur.Next(context.TODO()) {
-		//Create a value into which the single document can be decoded
-		var elem model.Option
-		err := cur.Decode(&elem)
-		if err != nil {
-			w = NewError(w, http.StatusBadRequest, err, "Failed to decode db result")
-			return
-		}
+	// Every query of the page reads the same generation, even if another is published meanwhile
+	snapshot, err := c.Repo.Snapshot(r.Context())
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to read the live generation")
+		return
+	}
+
+	// Count every matching document for the page totals
+	total, err := snapshot.CountOptions(r.Context(), collectionName, findFilter)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
+	}
 
-		options = append(options, elem)
+	// Find the boundaries of the page from the sort keys of its documents
+	keys, err := snapshot.OptionKeys(r.Context(), collectionName, page.Query(findFilter))
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+		return
 	}
 
-	if err := cur.Err(); err != nil {
-		w = NewError(w, http.StatusBadRequest, err, "Failed to iterate over db results")
+	keys, next, prev, err := page.Trim(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusInternalServerError, err, "Failed to create page cursors")
+		return
+	}
+
+	window, err := page.Window(keys)
+	if err != nil {
+		w = NewError(w, r, http.StatusBadRequest, err, "Failed to create page window")
 		return
 	}
 
-	//Close the cursor once finished
-	fmt.Printf("Found %d documents in %s", len(options), collectionName)
+	// Stream the documents of the page straight from the store
+	var stream store.Cursor
+	if len(keys) > 0 {
+		query := window.Query(findFilter)
+		query.Fields = fields.Projection()
+
+		stream, err = snapshot.FindOptions(r.Context(), collectionName, query)
+		if err != nil {
+			w = NewError(w, r, http.StatusBadRequest, err, "DB query failed; malformed filter or option")
+			return
+		}
+	}
+
+	logger.From(r.Context()).WithField("collection", collectionName).Debugf("Found %d documents", len(keys))
+
+	result := &PageStream{
+		Page:   page,
+		Total:  total,
+		Count:  len(keys),
+		Next:   next,
+		Prev:   prev,
+		Cursor: stream,
+		Fields: fields,
+		Format: format,
+		New:    func() interface{} { return new(model.Option) },
+	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(options)
+	result.Write(w, r)
 }
 
 // ListSubjects godoc
@@ -75,9 +113,11 @@ func (c *Controller) optionsEndpoint(collectionName string, w http.ResponseWrite
 // @Tags option
 // @ID options-list-subjects
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /subjects [get]
 func (c *Controller) ListSubjects(w http.ResponseWriter, r *http.Request) {
@@ -90,9 +130,11 @@ func (c *Controller) ListSubjects(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-suffixes
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /suffixes [get]
 func (c *Controller) ListSuffixes(w http.ResponseWriter, r *http.Request) {
@@ -105,9 +147,11 @@ func (c *Controller) ListSuffixes(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-delivery-types
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /deliveryTypes [get]
 func (c *Controller) ListDeliveryTypes(w http.ResponseWriter, r *http.Request) {
@@ -120,9 +164,11 @@ func (c *Controller) ListDeliveryTypes(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-components
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /components [get]
 func (c *Controller) ListComponents(w http.ResponseWriter, r *http.Request) {
@@ -135,9 +181,11 @@ func (c *Controller) ListComponents(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-start-times
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /startTimes [get]
 func (c *Controller) ListStartTimes(w http.ResponseWriter, r *http.Request) {
@@ -150,9 +198,11 @@ func (c *Controller) ListStartTimes(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-end-times
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /endTimes [get]
 func (c *Controller) ListEndTimes(w http.ResponseWriter, r *http.Request) {
@@ -165,9 +215,11 @@ func (c *Controller) ListEndTimes(w http.ResponseWriter, r *http.Request) {
 // @Tags option
 // @ID options-list-campuses
 // @Accept plain
-// @Produce json
+// @Produce json,application/x-ndjson
 // @Param test query OptionQueryParams false "Option filter, sort, pagination"
 // @Success 200 {array} model.Option
+// @Header 200 {integer} X-Total-Count "Number of options matching the filters"
+// @Header 200 {string} Link "RFC 8288 links to the next, previous and first pages"
 // @Failure 400 {object} HTTPError
 // @Router /campuses [get]
 func (c *Controller) ListCampuses(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"uwo-tt-api/store"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultLimit number of results in a page when a client does not ask for a limit
+const DefaultLimit = 100
+
+// MaxLimit largest number of results in a single page, larger limits are reduced to it
+const MaxLimit = 1000
+
+// Pagination describes the sort order and the page of a result set requested by a client
+type Pagination struct {
+	// Sort keys in priority order; the last key is always a unique tiebreaker so that the order is stable
+	Keys []string
+	// Direction of every sort key, 1 for ascending and -1 for descending
+	Dir int
+
+	Offset int64
+	Limit  int64
+	Cursor *Cursor
+
+	// Envelope wraps the results in a PageEnvelope instead of a bare array
+	Envelope bool
+
+	// window is set when the page fetches exactly Limit documents instead of one extra
+	window bool
+	// byOffset finds the adjacent pages by offset, for sort keys that may be missing and so cannot be compared in a filter
+	byOffset bool
+}
+
+// Cursor marks the boundary document of a page so the next or previous page can be requested
+type Cursor struct {
+	Values   bson.A `bson:"v"`
+	Backward bool   `bson:"b"`
+	// Inclusive cursors also match the document they are positioned at
+	Inclusive bool `bson:"i,omitempty"`
+	// Offset cursors mark the page starting at that many documents instead, for sort keys that cannot be used in a cursor
+	Offset *int64 `bson:"o,omitempty"`
+}
+
+// PageEnvelope is returned instead of a bare array when a client asks for envelope=true
+type PageEnvelope struct {
+	Total  int64       `json:"total" example:"1234"`
+	Count  int         `json:"count" example:"5"`
+	Offset int64       `json:"offset" example:"0"`
+	Limit  int64       `json:"limit" example:"5"`
+	Next   string      `json:"next,omitempty" example:"HwAAAARiAAUAAAAIYgAAAA"`
+	Prev   string      `json:"prev,omitempty" example:"HwAAAARiAAUAAAAIYgAAAA"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// NewPagination creates a pagination sorted by the given field, with _id as the tiebreaker
+func NewPagination(sortField string, dec bool) *Pagination {
+	page := &Pagination{Dir: 1}
+
+	if sortField != "" {
+		page.Keys = append(page.Keys, sortField)
+	}
+	page.Keys = append(page.Keys, "_id")
+
+	// By default, sort ascending unless descending is specfied
+	if dec {
+		page.Dir = -1
+	}
+
+	return page
+}
+
+// SetPage applies the offset, limit and cursor requested by a client
+func (page *Pagination) SetPage(offset int, limit int, cursor string, envelope bool) error {
+	if offset < 0 || limit < 0 {
+		return errors.New("Offset and limit must not be negative")
+	}
+
+	if limit > MaxLimit {
+		limit = MaxLimit
+	}
+
+	page.Offset = int64(offset)
+	page.Limit = int64(limit)
+	page.Envelope = envelope
+
+	if cursor != "" {
+		c, err := DecodeCursor(cursor)
+		if err != nil {
+			return err
+		}
+
+		if c.Offset != nil {
+			if *c.Offset < 0 {
+				return errors.New("Invalid cursor")
+			}
+
+			page.Offset = *c.Offset
+			return nil
+		}
+
+		if len(c.Values) != len(page.Keys) || page.byOffset {
+			return errors.New("Cursor does not match sort criteria")
+		}
+
+		page.Cursor = c
+	}
+
+	return nil
+}
+
+// EncodeCursor converts a cursor into an opaque url safe string
+func EncodeCursor(c *Cursor) (string, error) {
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// DecodeCursor converts an opaque string created by EncodeCursor back into a cursor
+func DecodeCursor(s string) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("Invalid cursor")
+	}
+
+	c := new(Cursor)
+	if err := bson.Unmarshal(raw, c); err != nil {
+		return nil, errors.New("Invalid cursor")
+	}
+
+	return c, nil
+}
+
+// backward reports if results are being fetched in the reverse of the requested order
+func (page *Pagination) backward() bool {
+	return page.Cursor != nil && page.Cursor.Backward
+}
+
+// desc reports if the query sorts descending, which is reversed when paging backwards
+func (page *Pagination) desc() bool {
+	return (page.Dir == -1) != page.backward()
+}
+
+// Filter adds the cursor position to a query filter so only documents past the cursor match
+func (page *Pagination) Filter(filter store.Filter) store.Filter {
+	if page.Cursor == nil {
+		return filter
+	}
+
+	op := store.Gt
+	if page.desc() {
+		op = store.Lt
+	}
+
+	// Documents after the cursor share every earlier key and move past it on the next one
+	after := store.Filter{Any: true}
+	for i, key := range page.Keys {
+		cond := store.Filter{}
+		for j := 0; j < i; j++ {
+			cond.Conditions = append(cond.Conditions, store.Condition{Field: page.Keys[j], Op: store.Eq, Value: page.Cursor.Values[j]})
+		}
+
+		last := store.Condition{Field: key, Op: op, Value: page.Cursor.Values[i]}
+		if page.Cursor.Inclusive && i == len(page.Keys)-1 {
+			if op == store.Gt {
+				last.Op = store.Gte
+			} else {
+				last.Op = store.Lte
+			}
+		}
+
+		cond.Conditions = append(cond.Conditions, last)
+		after.Filters = append(after.Filters, cond)
+	}
+
+	return store.And(filter, after)
+}
+
+// lookahead returns the number of documents to fetch. Unless the page is a window, one extra document is requested to know if another page exists
+func (page *Pagination) lookahead() int64 {
+	if page.window {
+		return page.Limit
+	}
+
+	return page.Limit + 1
+}
+
+// Query returns the query of the page for the documents matching a filter
+func (page *Pagination) Query(filter store.Filter) store.Query {
+	q := store.Query{
+		Filter: page.Filter(filter),
+		Sort:   page.Keys,
+		Desc:   page.desc(),
+	}
+
+	if page.Cursor == nil {
+		q.Skip = page.Offset
+	}
+
+	if page.Limit != 0 {
+		q.Limit = page.lookahead()
+	}
+
+	return q
+}
+
+// Window returns the page fetching exactly the documents whose sort keys were found by the page's query, in the requested order.
+// The page's documents can then be streamed without holding them in memory
+func (page *Pagination) Window(keys [][]interface{}) (*Pagination, error) {
+	window := *page
+	window.window = true
+	window.Limit = int64(len(keys))
+
+	// Backward pages are fetched forwards starting at their first document
+	if page.backward() && len(keys) > 0 {
+		if !usable(keys[0]) {
+			return nil, errors.New("Cursor does not match sort criteria")
+		}
+
+		window.Cursor = &Cursor{Values: keys[0], Inclusive: true}
+	}
+
+	return &window, nil
+}
+
+// Trim drops the extra document requested by Query, restores the requested order and creates cursors for the adjacent pages
+func (page *Pagination) Trim(keys [][]interface{}) (result [][]interface{}, next string, prev string, err error) {
+	more := page.Limit != 0 && int64(len(keys)) > page.Limit
+	if more {
+		keys = keys[:page.Limit]
+	}
+
+	if page.backward() {
+		for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+			keys[i], keys[j] = keys[j], keys[i]
+		}
+	}
+
+	if len(keys) == 0 {
+		return keys, "", "", nil
+	}
+
+	// When paging backwards the extra document belongs to the previous page and the next page is where the cursor came from
+	hasNext := more
+	hasPrev := page.Cursor != nil || page.Offset != 0
+	if page.backward() {
+		hasNext, hasPrev = true, more
+	}
+
+	// Without a limit every remaining document is already in the result
+	if page.Limit != 0 && hasNext {
+		if next, err = page.cursorAt(keys[len(keys)-1], false, page.Offset+int64(len(keys))); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	if page.Limit != 0 && hasPrev {
+		if prev, err = page.cursorAt(keys[0], true, page.Offset-page.Limit); err != nil {
+			return nil, "", "", err
+		}
+	}
+
+	return keys, next, prev, nil
+}
+
+// usable reports if every sort key of a document can be used in a cursor. Keys that cannot be compared, e.g. arrays, are nil
+func usable(values []interface{}) bool {
+	for _, value := range values {
+		if value == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// cursorAt creates a cursor positioned at the sort keys of a document. If the keys cannot be used in a cursor, e.g. when
+// sorting by meeting times, the cursor marks the page starting at offset instead, which is only known for pages found by offset
+func (page *Pagination) cursorAt(values []interface{}, backward bool, offset int64) (string, error) {
+	if usable(values) && !page.byOffset {
+		return EncodeCursor(&Cursor{Values: values, Backward: backward})
+	}
+
+	if page.Cursor != nil {
+		return "", errors.New("Sort keys cannot be used in a cursor")
+	}
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	return EncodeCursor(&Cursor{Offset: &offset})
+}
+
+// pageLink creates a relative link to the current request with the given query parameters replaced
+func pageLink(r *http.Request, set map[string]string, del ...string) string {
+	u := *r.URL
+	query := u.Query()
+
+	for _, key := range del {
+		query.Del(key)
+	}
+
+	for key, value := range set {
+		query.Set(key, value)
+	}
+
+	u.RawQuery = query.Encode()
+
+	return u.RequestURI()
+}
+
+// setPageHeaders sets the X-Total-Count and RFC 8288 Link headers of a page
+func setPageHeaders(w http.ResponseWriter, r *http.Request, page *Pagination, total int64, next string, prev string) {
+	links := []string{}
+
+	if next != "" {
+		links = append(links, "<"+pageLink(r, map[string]string{"cursor": next}, "offset")+`>; rel="next"`)
+	}
+
+	if prev != "" {
+		links = append(links, "<"+pageLink(r, map[string]string{"cursor": prev}, "offset")+`>; rel="prev"`)
+	}
+
+	if page.Limit != 0 {
+		links = append(links, "<"+pageLink(r, nil, "offset", "cursor")+`>; rel="first"`)
+	}
+
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/store"
+)
+
+// PageStream is a page of results that is written to the response as each document is decoded from the db cursor,
+// so the page is never held in memory
+type PageStream struct {
+	Page  *Pagination
+	Total int64
+	Count int
+	Next  string
+	Prev  string
+
+	// Cursor of the documents of the page, nil when the page is empty
+	Cursor store.Cursor
+	Fields Fields
+	Format string
+
+	// New creates a value into which a single document can be decoded
+	New func() interface{}
+}
+
+// Write writes the page as a JSON array, a PageEnvelope, one JSON document per line, or a table of meeting times
+func (s *PageStream) Write(w http.ResponseWriter, r *http.Request) {
+	setPageHeaders(w, r, s.Page, s.Total, s.Next, s.Prev)
+	w.Header().Set("Content-Type", formatTypes[s.Format])
+
+	switch s.Format {
+	case CSVFormat, XLSXFormat:
+		name := path.Base(r.URL.Path)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, s.Format))
+		w.WriteHeader(http.StatusOK)
+
+		s.writeTable(r.Context(), w, name)
+	case NDJSONFormat:
+		w.WriteHeader(http.StatusOK)
+
+		s.writeDocuments(r.Context(), w, true)
+	default:
+		w.WriteHeader(http.StatusOK)
+
+		if s.Page.Envelope {
+			// Everything but the data is known up front so the envelope is left open for the data
+			meta, _ := json.Marshal(PageEnvelope{
+				Total:  s.Total,
+				Count:  s.Count,
+				Offset: s.Page.Offset,
+				Limit:  s.Page.Limit,
+				Next:   s.Next,
+				Prev:   s.Prev,
+			})
+
+			w.Write(meta[:len(meta)-1])
+			w.Write([]byte(`,"data":`))
+		}
+
+		w.Write([]byte("["))
+		s.writeDocuments(r.Context(), w, false)
+		w.Write([]byte("]"))
+
+		if s.Page.Envelope {
+			w.Write([]byte("}"))
+		}
+
+		w.Write([]byte("\n"))
+	}
+}
+
+// each decodes every document of the cursor, closing it afterwards
+func (s *PageStream) each(ctx context.Context, fn func(i int, elem interface{}) error) {
+	if s.Cursor == nil {
+		return
+	}
+
+	defer s.Cursor.Close(ctx)
+
+	for i := 0; s.Cursor.Next(ctx); i++ {
+		//Create a value into which the single document can be decoded
+		elem := s.New()
+		if err := s.Cursor.Decode(elem); err != nil {
+			// The status is already sent so the response can only be cut short
+			logger.From(ctx).WithError(err).Error("Failed to decode db result while streaming")
+			return
+		}
+
+		if err := fn(i, elem); err != nil {
+			logger.From(ctx).WithError(err).Warn("Failed to write streamed result")
+			return
+		}
+	}
+
+	if err := s.Cursor.Err(); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to iterate over db results while streaming")
+	}
+}
+
+// writeDocuments encodes every document as JSON, separated by commas in an array or by newlines in NDJSON
+func (s *PageStream) writeDocuments(ctx context.Context, w http.ResponseWriter, ndjson bool) {
+	s.each(ctx, func(i int, elem interface{}) error {
+		data, err := s.Fields.Marshal(elem)
+		if err != nil {
+			return err
+		}
+
+		if ndjson {
+			data = append(data, '\n')
+		} else if i > 0 {
+			data = append([]byte(","), data...)
+		}
+
+		_, err = w.Write(data)
+		return err
+	})
+}
+
+// writeTable flattens every document into rows of meeting times
+func (s *PageStream) writeTable(ctx context.Context, w http.ResponseWriter, name string) {
+	table, err := newTableWriter(w, s.Format, name)
+	if err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to create table")
+		return
+	}
+
+	header := make([]interface{}, len(TableColumns))
+	for i, column := range TableColumns {
+		header[i] = column
+	}
+
+	if err := table.Write(header); err != nil {
+		logger.From(ctx).WithError(err).Warn("Failed to write table")
+		return
+	}
+
+	s.each(ctx, func(_ int, elem interface{}) error {
+		rows, err := TableRows(elem)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			if err := table.Write(row); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err := table.Close(); err != nil {
+		logger.From(ctx).WithError(err).Warn("Failed to write table")
+	}
+}
@@ -0,0 +1,269 @@
+package controller
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"uwo-tt-api/model"
+)
+
+// Response formats of list endpoints
+const (
+	JSONFormat   = "json"
+	NDJSONFormat = "ndjson"
+	CSVFormat    = "csv"
+	XLSXFormat   = "xlsx"
+)
+
+// Content types of the response formats
+const (
+	NDJSON = "application/x-ndjson"
+	CSV    = "text/csv"
+	XLSX   = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// formatTypes maps each response format to its content type
+var formatTypes = map[string]string{
+	JSONFormat:   "application/json",
+	NDJSONFormat: NDJSON,
+	CSVFormat:    CSV,
+	XLSXFormat:   XLSX,
+}
+
+// NegotiateFormat picks the response format from the format query parameter, or from the Accept header if it is not set.
+// Tabular formats are only available for results that can be flattened into a table
+func NegotiateFormat(r *http.Request, tabular bool) (string, error) {
+	if format := r.Form.Get("format"); format != "" {
+		if _, ok := formatTypes[format]; !ok || !tabular && (format == CSVFormat || format == XLSXFormat) {
+			return "", fmt.Errorf("Invalid format %s", format)
+		}
+
+		return format, nil
+	}
+
+	accept := r.Header.Get("Accept")
+
+	switch {
+	case strings.Contains(accept, NDJSON):
+		return NDJSONFormat, nil
+	case tabular && strings.Contains(accept, CSV):
+		return CSVFormat, nil
+	case tabular && strings.Contains(accept, XLSX):
+		return XLSXFormat, nil
+	}
+
+	return JSONFormat, nil
+}
+
+// TableColumns header row of tabular results, with one row per section meeting time
+var TableColumns = []string{
+	"faculty", "number", "suffix", "name", "description",
+	"section", "component", "classNumber", "location", "instructor", "requisites", "status", "campus", "delivery",
+	"day", "startTime", "endTime",
+}
+
+// sectionRows flattens a section into one row per meeting time, or a single row without times if it has none
+func sectionRows(course model.CourseComponent, section model.SectionComponent) [][]interface{} {
+	row := []interface{}{
+		course.Faculty, course.Number, course.Suffix, course.Name, course.Description,
+		section.Number, section.Component, section.ClassNumber, section.Location, section.Instructor,
+		section.Reqs, section.Status, section.Campus, section.Delivery,
+	}
+
+	if len(section.Times) == 0 {
+		return [][]interface{}{append(row, "", "", "")}
+	}
+
+	rows := [][]interface{}{}
+	for _, meeting := range section.Times {
+		timeRow := append(append([]interface{}{}, row...), meeting.Day, meeting.StartTime, meeting.EndTime)
+		rows = append(rows, timeRow)
+	}
+
+	return rows
+}
+
+// TableRows flattens a section or course result into rows of TableColumns
+func TableRows(elem interface{}) ([][]interface{}, error) {
+	switch result := elem.(type) {
+	case *model.Section:
+		return sectionRows(result.CourseData, result.SectionData), nil
+	case *model.Course:
+		rows := [][]interface{}{}
+		for _, section := range result.SectionData {
+			rows = append(rows, sectionRows(result.CourseData, section)...)
+		}
+
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("%T cannot be written as a table", elem)
+	}
+}
+
+// formulaPrefixes are the first characters of text that spreadsheets read as a formula
+const formulaPrefixes = "=+-@\t\r"
+
+// formulaLike reports if a value is text that spreadsheets would read as a formula, such as a scraped =HYPERLINK(...)
+func formulaLike(value interface{}) bool {
+	text, ok := value.(string)
+	return ok && text != "" && strings.IndexByte(formulaPrefixes, text[0]) >= 0
+}
+
+// tableWriter writes rows of a tabular response
+type tableWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+// newTableWriter creates the writer of a tabular format, named after the results it holds
+func newTableWriter(w io.Writer, format string, name string) (tableWriter, error) {
+	switch format {
+	case CSVFormat:
+		return &csvTable{csv.NewWriter(w)}, nil
+	case XLSXFormat:
+		return newXLSXTable(w, name)
+	default:
+		return nil, fmt.Errorf("Invalid table format %s", format)
+	}
+}
+
+// csvTable writes rows as comma separated values
+type csvTable struct {
+	writer *csv.Writer
+}
+
+func (t *csvTable) Write(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, value := range row {
+		record[i] = fmt.Sprint(value)
+
+		// A leading quote keeps spreadsheets opening the file from running text as a formula
+		if formulaLike(value) {
+			record[i] = "'" + record[i]
+		}
+	}
+
+	return t.writer.Write(record)
+}
+
+func (t *csvTable) Close() error {
+	t.writer.Flush()
+	return t.writer.Error()
+}
+
+// xlsxTable writes rows into the single sheet of a minimal spreadsheet. The sheet is the last file of the zip archive so rows are streamed into it
+type xlsxTable struct {
+	archive *zip.Writer
+	sheet   io.Writer
+}
+
+// xlsxParts files of a spreadsheet besides its sheet; %s is replaced by the sheet name
+var xlsxParts = []struct {
+	Name    string
+	Content string
+}{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets>` +
+		`</workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// The second cell format quotes text, so it stays text when the cell is edited
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+		`<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0" quotePrefix="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func newXLSXTable(w io.Writer, name string) (*xlsxTable, error) {
+	t := &xlsxTable{archive: zip.NewWriter(w)}
+
+	for _, part := range xlsxParts {
+		f, err := t.archive.Create(part.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		content := part.Content
+		if strings.Contains(content, "%s") {
+			content = fmt.Sprintf(content, xmlText(name))
+		}
+
+		if _, err := io.WriteString(f, content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := t.archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	t.sheet = sheet
+
+	return t, err
+}
+
+func (t *xlsxTable) Write(row []interface{}) error {
+	var b strings.Builder
+
+	b.WriteString("<row>")
+	for _, value := range row {
+		switch number, ok := value.(int); {
+		case ok:
+			b.WriteString("<c><v>" + strconv.Itoa(number) + "</v></c>")
+		case formulaLike(value):
+			// Inline strings are never evaluated, but are quoted too so editing the cell keeps it as text
+			b.WriteString(`<c t="inlineStr" s="1"><is><t xml:space="preserve">` + xmlText(fmt.Sprint(value)) + "</t></is></c>")
+		default:
+			b.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">` + xmlText(fmt.Sprint(value)) + "</t></is></c>")
+		}
+	}
+	b.WriteString("</row>")
+
+	_, err := io.WriteString(t.sheet, b.String())
+	return err
+}
+
+func (t *xlsxTable) Close() error {
+	if _, err := io.WriteString(t.sheet, "</sheetData></worksheet>"); err != nil {
+		return err
+	}
+
+	return t.archive.Close()
+}
+
+// xmlText escapes text for use in xml
+func xmlText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
@@ -0,0 +1,117 @@
+package controller
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// formulaRow holds text spreadsheets would read as formulas, along with plain values
+var formulaRow = []interface{}{`=HYPERLINK("http://example.com")`, "+1", "-2", "@SUM(A1)", "\tx", "CS", "", "a=b", 1026, -1}
+
+func TestCSVTableQuotesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+
+	table, err := newTableWriter(&buf, CSVFormat, "sections")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Write(formulaRow); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{`'=HYPERLINK("http://example.com")`, "'+1", "'-2", "'@SUM(A1)", "'\tx", "CS", "", "a=b", "1026", "-1"}}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("wrote %q, want %q", records, want)
+	}
+}
+
+// xlsxCell is a cell of a sheet, with its style and text
+type xlsxCell struct {
+	Type  string `xml:"t,attr"`
+	Style string `xml:"s,attr"`
+	Text  string `xml:"is>t"`
+	Value string `xml:"v"`
+}
+
+func TestXLSXTableQuotesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+
+	table, err := newTableWriter(&buf, XLSXFormat, "sections")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Write(formulaRow); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := table.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if files[f.Name], err = ioutil.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+
+		r.Close()
+	}
+
+	if !bytes.Contains(files["xl/styles.xml"], []byte(`quotePrefix="1"`)) {
+		t.Errorf("styles %s have no quoted cell format", files["xl/styles.xml"])
+	}
+
+	var sheet struct {
+		Rows []struct {
+			Cells []xlsxCell `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+
+	if err := xml.Unmarshal(files["xl/worksheets/sheet1.xml"], &sheet); err != nil {
+		t.Fatal(err)
+	}
+
+	// Text is written as it is, only quoted by its format
+	want := []xlsxCell{
+		{"inlineStr", "1", `=HYPERLINK("http://example.com")`, ""},
+		{"inlineStr", "1", "+1", ""},
+		{"inlineStr", "1", "-2", ""},
+		{"inlineStr", "1", "@SUM(A1)", ""},
+		{"inlineStr", "1", "\tx", ""},
+		{"inlineStr", "", "CS", ""},
+		{"inlineStr", "", "", ""},
+		{"inlineStr", "", "a=b", ""},
+		{"", "", "", "1026"},
+		{"", "", "", "-1"},
+	}
+
+	if len(sheet.Rows) != 1 || !reflect.DeepEqual(sheet.Rows[0].Cells, want) {
+		t.Errorf("wrote rows %+v, want %+v", sheet.Rows, want)
+	}
+}
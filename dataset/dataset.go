@@ -0,0 +1,180 @@
+package dataset
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+)
+
+// Export writes every option and section of a snapshot as a gzipped JSON model.Dataset stamped with the run that wrote them.
+// Documents are written as they are read so the dataset is never held in memory
+func Export(ctx context.Context, snapshot store.Snapshot, run model.Run, w io.Writer) error {
+	gz := gzip.NewWriter(w)
+
+	header, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprintf(gz, `{"version":%d,"run":%s,"options":{`, model.DatasetVersion, header); err != nil {
+		return err
+	}
+
+	for i, name := range model.OptionCollections {
+		if i > 0 {
+			gz.Write([]byte(","))
+		}
+
+		if _, err := fmt.Fprintf(gz, "%q:[", name); err != nil {
+			return err
+		}
+
+		cur, err := snapshot.FindOptions(ctx, name, store.Query{Sort: []string{"_id"}})
+		if err == nil {
+			err = writeAll(ctx, cur, gz, func() interface{} { return new(model.Option) })
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to export %s: %w", name, err)
+		}
+
+		gz.Write([]byte("]"))
+	}
+
+	gz.Write([]byte(`},"sections":[`))
+
+	cur, err := snapshot.FindSections(ctx, store.Query{Sort: []string{"_id"}})
+	if err == nil {
+		err = writeAll(ctx, cur, gz, func() interface{} { return new(model.Section) })
+	}
+
+	if err != nil {
+		return fmt.Errorf("Failed to export courses: %w", err)
+	}
+
+	if _, err := gz.Write([]byte("]}\n")); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// writeAll writes every remaining document of a cursor as the elements of a JSON array and closes it
+func writeAll(ctx context.Context, cur store.Cursor, w io.Writer, newElem func() interface{}) error {
+	defer cur.Close(ctx)
+
+	for i := 0; cur.Next(ctx); i++ {
+		elem := newElem()
+		if err := cur.Decode(elem); err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(elem)
+		if err != nil {
+			return err
+		}
+
+		if i > 0 {
+			data = append([]byte(","), data...)
+		}
+
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+
+	return cur.Err()
+}
+
+// Read decodes a gzipped JSON model.Dataset created by Export
+func Read(r io.Reader) (*model.Dataset, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	defer gz.Close()
+
+	data := new(model.Dataset)
+	if err := json.NewDecoder(gz).Decode(data); err != nil {
+		return nil, err
+	}
+
+	if data.Version != model.DatasetVersion {
+		return nil, fmt.Errorf("Unsupported dataset version %d", data.Version)
+	}
+
+	return data, nil
+}
+
+// Import publishes a dataset created by Export into a repository as a new generation. Nothing is published unless the repository
+// has no published data, so a snapshot is never mixed with scraped data
+func Import(ctx context.Context, repo store.Repository, r io.Reader) (model.Run, error) {
+	data, err := Read(r)
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	known := map[string]bool{}
+	for _, name := range model.OptionCollections {
+		known[name] = true
+	}
+
+	for name := range data.Options {
+		if !known[name] {
+			return model.Run{}, fmt.Errorf("Unknown option collection %s", name)
+		}
+	}
+
+	for _, name := range model.OptionCollections {
+		count, err := repo.CountOptions(ctx, name, store.Filter{})
+		if err != nil {
+			return model.Run{}, err
+		}
+
+		if count > 0 {
+			return model.Run{}, fmt.Errorf("Collection %s is not empty", name)
+		}
+	}
+
+	count, err := repo.CountSections(ctx, store.Filter{})
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	if count > 0 {
+		return model.Run{}, errors.New("Collection courses is not empty")
+	}
+
+	gen, err := repo.NewGeneration(ctx, data.Run.ID)
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	for name, opts := range data.Options {
+		if err := repo.WriteOptions(ctx, gen.Number, name, opts); err != nil {
+			return model.Run{}, fmt.Errorf("Failed to import %s: %w", name, err)
+		}
+	}
+
+	if err := repo.WriteSections(ctx, gen.Number, data.Sections); err != nil {
+		return model.Run{}, fmt.Errorf("Failed to import courses: %w", err)
+	}
+
+	// Keep the run so the imported data is stamped with the same run it was exported with
+	data.Run.Generation = gen.Number
+	if err := repo.SaveRun(ctx, data.Run); err != nil {
+		return model.Run{}, err
+	}
+
+	if err := repo.PublishGeneration(ctx, gen.Number); err != nil {
+		return model.Run{}, err
+	}
+
+	return data.Run, nil
+}
@@ -8,14 +8,17 @@ require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751
 	github.com/cpuguy83/go-md2man/v2 v2.0.0 // indirect
 	github.com/gin-gonic/gin v1.6.3
-	github.com/go-co-op/gocron v0.2.0
 	github.com/go-openapi/spec v0.19.8 // indirect
 	github.com/go-openapi/swag v0.19.9 // indirect
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/schema v1.1.0
+	github.com/lib/pq v1.8.0
 	github.com/mailru/easyjson v0.7.1 // indirect
-	github.com/moesif/moesifmiddleware-go v1.0.6
+	github.com/moesif/moesifapi-go v1.0.4
+	github.com/prometheus/client_golang v1.5.1
+	github.com/robfig/cron/v3 v3.0.1
+	github.com/sirupsen/logrus v1.5.0
 	github.com/spf13/viper v1.7.0
 	github.com/swaggo/files v0.0.0-20190704085106-630677cd5c14
 	github.com/swaggo/gin-swagger v1.2.0
@@ -25,9 +28,11 @@ require (
 	github.com/ulule/limiter/v3 v3.5.0
 	github.com/urfave/cli v1.22.4 // indirect
 	github.com/urfave/cli/v2 v2.2.0 // indirect
+	go.etcd.io/bbolt v1.3.5
 	go.mongodb.org/mongo-driver v1.3.4
 	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
 	golang.org/x/text v0.3.3
 	golang.org/x/tools v0.0.0-20200622150058-fcc5b64fe1f1 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
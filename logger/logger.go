@@ -0,0 +1,138 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// RequestIDHeader carries the id of a request, set by the client or a proxy in front of the server, otherwise by the server
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestID is the longest request id taken from a client, longer ones are replaced
+const maxRequestID = 128
+
+// fieldsKey is the context key holding the fields of log lines
+type fieldsKey struct{}
+
+// Setup logs at the given level, as JSON for FORMAT json or as text for text. The auto format logs JSON in release mode
+func Setup(format string, level string, release bool) error {
+	lvl, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	logrus.SetLevel(lvl)
+
+	if format == "json" || (format == "auto" && release) {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	} else {
+		logrus.SetFormatter(&logrus.TextFormatter{FullTimestamp: true})
+	}
+
+	return nil
+}
+
+// With returns a copy of ctx whose log lines carry the field
+func With(ctx context.Context, key string, value interface{}) context.Context {
+	fields := logrus.Fields{key: value}
+	for k, v := range fieldsOf(ctx) {
+		if k != key {
+			fields[k] = v
+		}
+	}
+
+	return context.WithValue(ctx, fieldsKey{}, fields)
+}
+
+// From returns the logger of ctx, which adds the fields of ctx to every line
+func From(ctx context.Context) *logrus.Entry {
+	return logrus.WithFields(fieldsOf(ctx))
+}
+
+// fieldsOf returns the fields of ctx, nil if it has none
+func fieldsOf(ctx context.Context) logrus.Fields {
+	fields, _ := ctx.Value(fieldsKey{}).(logrus.Fields)
+	return fields
+}
+
+// newRequestID creates a random request id
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Requests gives every request an id, kept from its X-Request-ID header if it has one, and returns it in the same header.
+// The log lines of the request carry the id, and once it is served its method, route, status and duration are logged
+func Requests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestID {
+			id = newRequestID()
+		}
+
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(With(c.Request.Context(), "request_id", id))
+
+		start := time.Now()
+		c.Next()
+
+		entry := From(c.Request.Context()).WithFields(logrus.Fields{
+			"method":   c.Request.Method,
+			"path":     c.Request.URL.Path,
+			"route":    c.FullPath(),
+			"status":   c.Writer.Status(),
+			"duration": time.Since(start).Seconds(),
+			"client":   c.ClientIP(),
+		})
+
+		if c.Writer.Status() >= 500 {
+			entry.Error("Request failed")
+		} else {
+			entry.Info("Request served")
+		}
+	}
+}
+
+// Commands logs the commands sent to MongoDB at debug level, and failed commands as warnings, with the fields of the context
+// they were sent in. The commands are passed on to next, if it is not nil
+func Commands(next *event.CommandMonitor) *event.CommandMonitor {
+	if next == nil {
+		next = &event.CommandMonitor{}
+	}
+
+	return &event.CommandMonitor{
+		Started: next.Started,
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			if next.Succeeded != nil {
+				next.Succeeded(ctx, e)
+			}
+
+			From(ctx).WithFields(logrus.Fields{
+				"command":  e.CommandName,
+				"duration": time.Duration(e.DurationNanos).Seconds(),
+			}).Debug("MongoDB command succeeded")
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			if next.Failed != nil {
+				next.Failed(ctx, e)
+			}
+
+			From(ctx).WithFields(logrus.Fields{
+				"command":  e.CommandName,
+				"duration": time.Duration(e.DurationNanos).Seconds(),
+				"error":    e.Failure,
+			}).Warn("MongoDB command failed")
+		},
+	}
+}
@@ -2,18 +2,21 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"database/sql"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/moesif/moesifmiddleware-go"
-	"github.com/spf13/viper"
+	_ "github.com/lib/pq" // Postgres driver for database/sql
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 
-	"github.com/go-co-op/gocron"
-
 	"github.com/gin-gonic/gin"
 
 	swaggerFiles "github.com/swaggo/files"
@@ -22,154 +25,337 @@ import (
 	mgin "github.com/ulule/limiter/v3/drivers/middleware/gin"
 	memory "github.com/ulule/limiter/v3/drivers/store/memory"
 
+	"uwo-tt-api/analytics"
+	"uwo-tt-api/config"
 	"uwo-tt-api/controller"
+	"uwo-tt-api/dataset"
 	_ "uwo-tt-api/docs" // docs is generated by Swag CLI, you have to import it.
+	"uwo-tt-api/logger"
+	"uwo-tt-api/metrics"
+	"uwo-tt-api/store"
 	"uwo-tt-api/worker"
 )
 
-func wrapHandlerMoesif(f http.HandlerFunc, s map[string]interface{}) gin.HandlerFunc {
-	return gin.WrapH(moesifmiddleware.MoesifMiddleware(http.HandlerFunc(f), s))
+// wrapHandler serves a net/http handler of the controller from gin
+func wrapHandler(f http.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Hand the path parameters matched by gin to the net/http handler
+		params := make(map[string]string, len(c.Params))
+		for _, param := range c.Params {
+			params[param.Key] = param.Value
+		}
+
+		f(c.Writer, controller.WithPathParams(c.Request, params))
+	}
 }
 
-func getPort() string {
-	// value, ok when needed
-	val, ok := viper.Get("PORT").(string)
+// connectDB connects to the MongoDB database of the mode the server runs in
+func connectDB(ctx context.Context, cfg config.Config) *mongo.Database {
+	ctx, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
+
+	// Commands are timed, and logged with the request or scrape run that sent them
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(cfg.MongoURL()).SetMonitor(logger.Commands(metrics.CommandMonitor())))
 
-	if !ok {
-		log.Printf("Port does not exist or has invalid type")
-		val = "8080" // Default value
+	if err != nil {
+		logrus.Fatal(err)
 	}
 
-	return ":" + val
+	// Check the connection
+	err = client.Ping(ctx, nil)
+
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.WithField("release", cfg.Release()).Info("Connected to MongoDB!")
+
+	return client.Database("uwo-tt-api")
 }
 
-func getMoesifOptions() map[string]interface{} {
+// connectPostgres connects to the Postgres database of the mode the server runs in and brings its schema up to date
+func connectPostgres(ctx context.Context, cfg config.Config) *store.Postgres {
+	db, err := sql.Open("postgres", cfg.PostgresURL())
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	// value, ok when needed
-	val, ok := viper.Get("MOESIF_SECRET_ID").(string)
+	ping, cancel := context.WithTimeout(ctx, cfg.ConnectTimeout)
+	defer cancel()
 
-	if !ok {
-		log.Printf("Moesif key does not exist or has invalid type")
-		val = ""
+	if err := db.PingContext(ping); err != nil {
+		logrus.Fatal(err)
 	}
 
-	var moesifOptions = map[string]interface{}{
-		"Application_Id": val,
-		// Set to false if you don't want to capture req/resp body
-		"Log_Body": true,
+	logrus.WithField("release", cfg.Release()).Info("Connected to PostgreSQL!")
+
+	repo := store.NewPostgres(db)
+	if err := repo.Migrate(ctx); err != nil {
+		logrus.Fatal(err)
 	}
 
-	return moesifOptions
+	return repo
 }
 
-// TODO: Could use a struct to hold config information...
-func loadConfig() {
-	// Load environment configuration
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
+// openBolt opens the embedded database file, creating it if it does not exist
+func openBolt(cfg config.Config) *store.Bolt {
+	db, err := bolt.Open(cfg.BoltFile, 0600, &bolt.Options{Timeout: cfg.ConnectTimeout})
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
+	repo, err := store.NewBolt(db)
 	if err != nil {
-		log.Println("Error in config found!")
-		// If loading .env file fails, use variables sourced into the environment
-		log.Printf("Error reading config file %s. Using environment variables instead.", err)
+		logrus.Fatalf("Failed to load %s: %s", cfg.BoltFile, err)
 	}
 
-	viper.AutomaticEnv()
+	logrus.WithField("file", cfg.BoltFile).Info("Opened embedded database")
+
+	return repo
 }
 
-// @title Unofficial UWO Timetable API
-// @version 1.0
-// @description This is an API based on UWO's most update time table for undergraduate courses. Search options and course data is scraped from https://studentservices.uwo.ca/secure/timetables/mastertt/ttindex.cfm and stored in a database to avoid overloading the website with scrape requests. Data is scraped daily to ensure data is up-to-date.
+// connectRepository connects to the database selected by STORAGE: mongo, postgres or bolt for an embedded file
+func connectRepository(ctx context.Context, cfg config.Config) store.Repository {
+	switch cfg.Storage {
+	case "postgres":
+		return connectPostgres(ctx, cfg)
+	case "bolt":
+		return openBolt(cfg)
+	}
 
-// @contact.name API Support
-// @contact.url http://www.github.com/cbaron3/uwo-tt-api
+	return store.NewMongo(connectDB(ctx, cfg))
+}
 
-// @host http://uwottapi.ca
-// @BasePath /api/v1/
-func main() {
-	fmt.Println("Here")
-	loadConfig()
+// loadSnapshot loads a dataset snapshot file into memory
+func loadSnapshot(ctx context.Context, path string) store.Repository {
+	f, err := os.Open(path)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	defer f.Close()
 
-	fmt.Println("Config loaded")
+	repo := store.NewMemory()
+
+	run, err := dataset.Import(ctx, repo, f)
+	if err != nil {
+		logrus.Fatalf("Failed to load dataset: %s", err)
+	}
+
+	logrus.WithFields(logrus.Fields{"run": run.ID, "file": path}).Info("Serving scrape run from dataset")
+
+	return repo
+}
 
-	// Mongo URL
-	mode, modeOK := viper.Get("GIN_MODE").(string)
-	localDB, localOK := viper.Get("LOCAL_MONGODB").(string)
-	remoteDB, remoteOK := viper.Get("PROD_MONGODB").(string)
+// startScraping scrapes right away unless the live data is fresh, then as scheduled. Only one instance scrapes at a time, and
+// a scrape interrupted by the death of its instance is taken over. Scraping stops once ctx is cancelled
+func startScraping(ctx context.Context, cfg config.Config, repo store.Repository) {
+	schedule, err := cfg.Schedule()
+	if err != nil {
+		logrus.Fatal(err)
+	}
 
-	dbURL := ""
-	if modeOK && mode == "release" {
-		fmt.Println("Production")
+	ttl := cfg.ScrapeLeaseTTL
+	scrape := func(ctx context.Context) { scrapeOnce(ctx, cfg, repo, nil) }
 
-		if remoteOK {
-			dbURL = remoteDB
+	go func() {
+		if worker.ScrapeDue(ctx, repo, cfg.ScrapeFreshFor) {
+			scrapeExclusively(ctx, repo, ttl, scrape)
 		} else {
-			log.Fatalf("Production database url not found")
+			logrus.Info("Live data is recent, waiting for the next scheduled scrape")
 		}
 
-	} else {
-		fmt.Println("Local")
+		schedule.Run(ctx, func(run worker.ScheduledRun) {
+			scrapeExclusively(ctx, repo, ttl, func(ctx context.Context) { scrapeOnce(ctx, cfg, repo, run.Subjects) })
+		})
+	}()
+
+	// The lease of a dead instance expires after ttl, so its scrape can be resumed by then
+	go func() {
+		ticker := time.NewTicker(ttl)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if worker.Interrupted(ctx, repo) {
+				scrapes.Add(1)
+				worker.WithLease(ctx, repo, worker.ScrapeLease, ttl, scrape)
+				scrapes.Done()
+			}
+		}
+	}()
+}
 
-		if localOK {
-			dbURL = localDB
+// openAnalytics opens the sink selected by ANALYTICS that requests are recorded in. Mongo analytics share the connection of
+// the repository if it is stored in MongoDB
+func openAnalytics(ctx context.Context, cfg config.Config, repo store.Repository) analytics.Sink {
+	switch cfg.AnalyticsSink() {
+	case "moesif":
+		return analytics.NewMoesif(cfg.MoesifSecretID)
+	case "file":
+		return analytics.NewFile(cfg.AnalyticsFile, cfg.AnalyticsFileMaxSize, cfg.AnalyticsFileBackups)
+	case "mongo":
+		if m, ok := repo.(*store.Mongo); ok {
+			return analytics.NewMongo(m.DB)
+		}
+
+		return analytics.NewMongo(connectDB(ctx, cfg))
+	}
+
+	return analytics.Nop{}
+}
+
+// limitTime cancels the context of every request after a timeout, which stops its database queries
+func limitTime(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// shutdownContext is cancelled once the process is asked to stop by SIGINT or SIGTERM. A second signal kills it
+func shutdownContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+
+		logrus.WithField("signal", sig.String()).Info("Shutting down")
+		cancel()
+	}()
+
+	return ctx
+}
+
+// allowOrigins lets browsers on the given origins call the API, any origin if one of them is *
+func allowOrigins(origins []string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !(allowed["*"] || allowed[origin]) {
+			c.Next()
+			return
+		}
+
+		if allowed["*"] {
+			c.Header("Access-Control-Allow-Origin", "*")
 		} else {
-			log.Printf("Local database url not found, using localhost")
-			dbURL = "mongodb://mongodb:27017"
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		}
+
+		// Answer preflight requests without passing them on
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			c.Header("Access-Control-Max-Age", "86400")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
 		}
+
+		c.Next()
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// @title Unofficial UWO Timetable API
+// @version 1.0
+// @description This is an API based on UWO's most update time table for undergraduate courses. Search options and course data is scraped from https://studentservices.uwo.ca/secure/timetables/mastertt/ttindex.cfm and stored in a database to avoid overloading the website with scrape requests. Data is scraped daily to ensure data is up-to-date.
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(dbURL))
+// @contact.name API Support
+// @contact.url http://www.github.com/cbaron3/uwo-tt-api
 
+// @host http://uwottapi.ca
+// @BasePath /api/v1/
+func main() {
+	flag.Usage = printUsage
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if err != nil {
+	if err := logger.Setup(cfg.LogFormat, cfg.LogLevel, cfg.Release()); err != nil {
 		log.Fatal(err)
 	}
 
-	// Check the connection
-	err = client.Ping(context.TODO(), nil)
+	ctx := shutdownContext()
 
-	if err != nil {
-		log.Fatal(err)
+	logrus.Debug("Config loaded")
+
+	// Without a command the API is served along with the scraper, as it always was
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve", "--scrape"}
 	}
 
-	fmt.Println("Connected to MongoDB!")
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(ctx, cfg, newFlags(cmd), args[1:])
+			return
+		}
+	}
 
-	db := client.Database("uwo-tt-api")
+	printUsage()
 
-	// Start a scheduler with worker task
-	s1 := gocron.NewScheduler(time.UTC)
-	s1.Every(1).Day().StartImmediately().Do(worker.ScrapeTimeTable, db)
-	s1.StartAsync()
+	switch args[0] {
+	case "help", "-h", "--help":
+		return
+	}
 
+	os.Exit(2)
+}
+
+// serve serves the API until ctx is cancelled, then lets the requests in flight finish for up to SHUTDOWN_TIMEOUT
+func serve(ctx context.Context, cfg config.Config, repo store.Repository) {
 	// Endpoint router
-	router := gin.Default()
+	gin.SetMode(cfg.Mode)
+	router := gin.New()
+	router.Use(gin.Recovery(), logger.Requests(), metrics.Measure())
 
 	// Define controller instance for endpoints
 	c := controller.NewController()
-	c.DB = db
+	c.Repo = repo
+	c.AdminToken = cfg.AdminToken
+	c.Rescrape = func(subjects []string) (bool, error) { return startScrapeSubjects(ctx, cfg, repo, subjects) }
+	c.MaxAge = cfg.FreshnessMaxAge
 
-	// Get moesif configuration
-	moesifOptions := getMoesifOptions()
+	// Record the requests to the API where ANALYTICS says
+	recorder := analytics.NewRecorder(openAnalytics(ctx, cfg, repo), cfg.Recording())
+	logrus.WithField("analytics", cfg.AnalyticsSink()).Info("Recording requests")
 
-	// Define a limit rate to 4 requests per hour.
-	rate, err := limiter.NewRateFromFormatted("120-H")
+	// Limit the requests of each client to RATE_LIMIT, 120 an hour by default
+	rate, err := limiter.NewRateFromFormatted(cfg.RateLimit)
 	if err != nil {
-		log.Fatal(err)
+		logrus.Fatal(err)
 		return
 	}
 
 	store := memory.NewStore()
 
-	// Create a new middleware with the limiter instance.
-	middleware := mgin.NewMiddleware(limiter.New(store, rate))
+	// Create a new middleware with the limiter instance, counting the requests it refuses
+	middleware := mgin.NewMiddleware(limiter.New(store, rate), mgin.WithLimitReachedHandler(metrics.LimitReached))
 
-	router.Use(middleware)
+	// Probes of the orchestrator and its metrics scraper are registered first, so they are neither rate limited nor recorded
+	router.GET("/healthz", limitTime(cfg.RequestTimeout), gin.WrapF(c.Healthz))
+	router.GET("/readyz", limitTime(cfg.RequestTimeout), gin.WrapF(c.Readyz))
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	router.Use(allowOrigins(cfg.CORSOrigins), middleware, limitTime(cfg.RequestTimeout))
 
 	// Swagger documentation
 	router.NoRoute(func(c *gin.Context) {
@@ -179,23 +365,57 @@ func main() {
 
 	router.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
 
-	// API group
-	api := router.Group("/api/v1")
+	// Admin endpoints, kept out of the analytics as they carry the admin token
+	if c.AdminToken != "" {
+		router.POST("/api/v1/admin/scrape", gin.WrapF(c.ScrapeSubjects))
+	}
+
+	// API group, whose requests are recorded
+	api := router.Group("/api/v1", recorder.Middleware())
 	{
 		// Option endpoints
-		api.GET("/subjects", wrapHandlerMoesif(c.ListSubjects, moesifOptions))
-		api.GET("/suffixes", wrapHandlerMoesif(c.ListSuffixes, moesifOptions))
-		api.GET("/delivery_types", wrapHandlerMoesif(c.ListDeliveryTypes, moesifOptions))
-		api.GET("/components", wrapHandlerMoesif(c.ListComponents, moesifOptions))
-		api.GET("/start_times", wrapHandlerMoesif(c.ListStartTimes, moesifOptions))
-		api.GET("/end_times", wrapHandlerMoesif(c.ListEndTimes, moesifOptions))
-		api.GET("/campuses", wrapHandlerMoesif(c.ListCampuses, moesifOptions))
+		api.GET("/subjects", wrapHandler(c.ListSubjects))
+		api.GET("/suffixes", wrapHandler(c.ListSuffixes))
+		api.GET("/delivery_types", wrapHandler(c.ListDeliveryTypes))
+		api.GET("/components", wrapHandler(c.ListComponents))
+		api.GET("/start_times", wrapHandler(c.ListStartTimes))
+		api.GET("/end_times", wrapHandler(c.ListEndTimes))
+		api.GET("/campuses", wrapHandler(c.ListCampuses))
 
 		// Course data endpoint
-		api.GET("/courses", wrapHandlerMoesif(c.ListCourses, moesifOptions))
-		api.GET("/sections", wrapHandlerMoesif(c.ListSections, moesifOptions))
+		api.GET("/courses", wrapHandler(c.ListCourses))
+		api.GET("/sections", wrapHandler(c.ListSections))
+
+		// Course and section resources
+		api.GET("/courses/:subject/:number", wrapHandler(c.GetCourse))
+		api.GET("/sections/:classNumber", wrapHandler(c.GetSection))
+
+		// Dataset snapshot endpoint
+		api.GET("/dataset/latest", wrapHandler(c.GetLatestDataset))
+
+		// Data freshness endpoint
+		api.GET("/freshness", wrapHandler(c.GetFreshness))
+	}
+
+	server := &http.Server{Addr: cfg.Address(), Handler: router}
+
+	go func() {
+		logrus.WithField("address", server.Addr).Info("Listening")
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logrus.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+
+	shutdown, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdown); err != nil {
+		logrus.WithError(err).Warn("Failed to finish requests")
 	}
 
-	port := getPort()
-	router.Run(port)
+	if err := recorder.Close(shutdown); err != nil {
+		logrus.WithError(err).Warn("Failed to record the last requests")
+	}
 }
@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+// Requests counts the requests served, by route, method and status
+var Requests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "uwo_tt_api_http_requests_total",
+	Help: "Requests served, by route, method and status.",
+}, []string{"route", "method", "status"})
+
+// RequestDuration times the requests served, by route, method and status
+var RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "uwo_tt_api_http_request_duration_seconds",
+	Help:    "Time taken to serve requests, by route, method and status.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"route", "method", "status"})
+
+// RateLimited counts the requests refused for going over the rate limit, by route
+var RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "uwo_tt_api_rate_limit_rejections_total",
+	Help: "Requests refused for going over the rate limit, by route.",
+}, []string{"route"})
+
+// QueryDuration times the MongoDB commands, by command and whether they succeeded
+var QueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "uwo_tt_api_mongo_command_duration_seconds",
+	Help:    "Time taken by MongoDB commands, by command and outcome.",
+	Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 10},
+}, []string{"command", "outcome"})
+
+// ScrapeDuration times the scrape runs, by kind (full or subjects) and the status they finished with
+var ScrapeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "uwo_tt_api_scrape_run_duration_seconds",
+	Help:    "Time taken by scrape runs, by kind and final status.",
+	Buckets: []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800},
+}, []string{"kind", "status"})
+
+// ScrapePhaseDuration times the phases of a scrape run: scraping the options, then the courses
+var ScrapePhaseDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "uwo_tt_api_scrape_phase_duration_seconds",
+	Help:    "Time taken by each phase of a scrape run.",
+	Buckets: []float64{.01, .1, 1, 10, 60, 300, 900, 3600, 7200, 14400},
+}, []string{"phase"})
+
+// SubjectFetchDuration times fetching the page of a subject from the timetable
+var SubjectFetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name:    "uwo_tt_api_scrape_subject_fetch_duration_seconds",
+	Help:    "Time taken to fetch the page of a subject from the timetable.",
+	Buckets: []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+})
+
+// SubjectErrors counts the problems scraping subjects, by stage: fetch, parse or write
+var SubjectErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "uwo_tt_api_scrape_subject_errors_total",
+	Help: "Problems scraping subjects, by stage.",
+}, []string{"stage"})
+
+// DocumentsParsed counts the documents parsed from the timetable, by collection
+var DocumentsParsed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "uwo_tt_api_scrape_documents_parsed_total",
+	Help: "Documents parsed from the timetable, by collection.",
+}, []string{"collection"})
+
+// DocumentsPublished is the number of sections in the generation published last
+var DocumentsPublished = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "uwo_tt_api_scrape_documents_published",
+	Help: "Sections in the generation published last.",
+})
+
+// LastPublished is when a scrape last published a generation, as a Unix timestamp
+var LastPublished = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "uwo_tt_api_scrape_last_success_timestamp_seconds",
+	Help: "Unix time a scrape last published a generation.",
+})
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// route names the route gin matched for a request, so paths with parameters count as one
+func route(c *gin.Context) string {
+	if path := c.FullPath(); path != "" {
+		return path
+	}
+
+	return "unmatched"
+}
+
+// Measure counts and times every request that passes through it
+func Measure() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := strconv.Itoa(c.Writer.Status())
+		Requests.WithLabelValues(route(c), c.Request.Method, status).Inc()
+		RequestDuration.WithLabelValues(route(c), c.Request.Method, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// LimitReached counts a request refused by the rate limiter, then refuses it the way the limiter does
+func LimitReached(c *gin.Context) {
+	RateLimited.WithLabelValues(route(c)).Inc()
+	c.String(http.StatusTooManyRequests, "Limit exceeded")
+}
+
+// CommandMonitor times the commands sent to MongoDB
+func CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			QueryDuration.WithLabelValues(e.CommandName, "success").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			QueryDuration.WithLabelValues(e.CommandName, "failure").Observe(time.Duration(e.DurationNanos).Seconds())
+		},
+	}
+}
@@ -0,0 +1,23 @@
+package model
+
+// DatasetVersion version of the dataset snapshot format
+const DatasetVersion = 1
+
+// OptionCollections names of the collections holding the form options scraped from the timetable
+var OptionCollections = []string{
+	"subjects",
+	"suffixes",
+	"course_types",
+	"components",
+	"campuses",
+	"start_times",
+	"end_times",
+}
+
+// Dataset snapshot of every option and section published by a scrape run
+type Dataset struct {
+	Version  int                 `json:"version" example:"1"`
+	Run      Run                 `json:"run"`
+	Options  map[string][]Option `json:"options"`
+	Sections []Section           `json:"sections"`
+}
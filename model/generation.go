@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+)
+
+// Generation is a numbered copy of every option collection and section, written by a single run.
+// Only the live generation is served; older generations are kept so the data can be rolled back
+type Generation struct {
+	Number  int64     `bson:"_id" json:"number" example:"12"`
+	Run     string    `bson:"run" json:"run" example:"5ef3b1c2a4d2f1a2b3c4d5e6"`
+	Created time.Time `bson:"created" json:"created"`
+	// Published is when the generation was first served, zero while it is still being written
+	Published time.Time `bson:"published" json:"published"`
+	Live      bool      `bson:"-" json:"live"`
+}
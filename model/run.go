@@ -0,0 +1,39 @@
+package model
+
+import (
+	"time"
+)
+
+// Run statuses
+const (
+	RunRunning     = "running"
+	RunPublished   = "published"
+	RunQuarantined = "quarantined"
+	RunAbandoned   = "abandoned"
+)
+
+// Run records a single scrape of the timetable and the data it published
+type Run struct {
+	ID       string    `bson:"_id" json:"id" example:"5ef3b1c2a4d2f1a2b3c4d5e6"`
+	Started  time.Time `bson:"started" json:"started"`
+	Finished time.Time `bson:"finished" json:"finished"`
+	Status   string    `bson:"status" json:"status" example:"published"`
+	Subjects int       `bson:"subjects" json:"subjects" example:"180"`
+	Sections int       `bson:"sections" json:"sections" example:"4123"`
+	// Generation holds the data written by the run
+	Generation int64 `bson:"generation" json:"generation" example:"12"`
+	// Problems are the validation checks a quarantined run failed
+	Problems []string `bson:"problems,omitempty" json:"problems,omitempty"`
+	// Targets are the only subjects scraped by a targeted run, which keeps the live sections of every other subject
+	Targets []string `bson:"targets,omitempty" json:"targets,omitempty" example:"COMPSCI"`
+	// Checkpoints are the subjects the run has written, so an interrupted run can resume after them
+	Checkpoints []Checkpoint `bson:"checkpoints,omitempty" json:"checkpoints,omitempty"`
+}
+
+// Checkpoint records a subject whose sections a run has written
+type Checkpoint struct {
+	Subject           string `bson:"subject" json:"subject" example:"COMPSCI"`
+	Sections          int    `bson:"sections" json:"sections" example:"42"`
+	SectionsWithTimes int    `bson:"sectionsWithTimes" json:"sectionsWithTimes" example:"40"`
+	Errors            int    `bson:"errors" json:"errors" example:"0"`
+}
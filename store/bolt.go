@@ -0,0 +1,484 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+	"uwo-tt-api/model"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Bolt stores everything in a single bbolt file, so the API runs as one binary without a database server.
+// Each generation is a bucket in the generations bucket, holding a bucket of bson documents for each of its collections, keyed
+// in the order they were written. The number of the live generation is kept in the meta bucket, so every collection is published
+// at once by a single write. Queries are answered from a copy of the live generation held in memory, which is replaced whenever
+// a generation is published
+type Bolt struct {
+	DB *bolt.DB
+
+	published *Memory
+}
+
+// NewBolt creates a repository backed by a bbolt file and loads its live generation
+func NewBolt(db *bolt.DB) (*Bolt, error) {
+	b := &Bolt{DB: db, published: NewMemory()}
+
+	var info model.Generation
+	var options map[string][]model.Option
+	var sections []model.Section
+
+	err := db.View(func(tx *bolt.Tx) error {
+		if runs := tx.Bucket([]byte("runs")); runs != nil {
+			err := decodeAll(runs, func() interface{} { return new(model.Run) }, func(v interface{}) {
+				b.published.SaveRun(context.TODO(), *v.(*model.Run))
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		live := liveGeneration(tx)
+		if live == 0 {
+			return nil
+		}
+
+		var err error
+		info, options, sections, err = loadGeneration(tx, live)
+
+		return err
+	})
+	if err != nil || info.Number == 0 {
+		return b, err
+	}
+
+	return b, b.published.serve(info, options, sections)
+}
+
+// liveGeneration reads the number of the live generation, or 0 if none was published
+func liveGeneration(tx *bolt.Tx) int64 {
+	meta := tx.Bucket([]byte("meta"))
+	if meta == nil {
+		return 0
+	}
+
+	live := meta.Get([]byte("live"))
+	if live == nil {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(live))
+}
+
+// generationBucket finds the bucket of a generation
+func generationBucket(tx *bolt.Tx, number int64) (*bolt.Bucket, error) {
+	if gens := tx.Bucket([]byte("generations")); gens != nil {
+		if gen := gens.Bucket(seqKey(uint64(number))); gen != nil {
+			return gen, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Generation %d does not exist", number)
+}
+
+// readGeneration decodes the information about a generation
+func readGeneration(gen *bolt.Bucket) (model.Generation, error) {
+	var info model.Generation
+	err := bson.Unmarshal(gen.Get([]byte("info")), &info)
+
+	return info, err
+}
+
+// loadGeneration decodes every collection of a generation
+func loadGeneration(tx *bolt.Tx, number int64) (info model.Generation, options map[string][]model.Option,
+	sections []model.Section, err error) {
+	gen, err := generationBucket(tx, number)
+	if err != nil {
+		return info, nil, nil, err
+	}
+
+	info, err = readGeneration(gen)
+	if err != nil {
+		return info, nil, nil, err
+	}
+
+	options = map[string][]model.Option{}
+	sections = []model.Section{}
+
+	err = gen.ForEach(func(name, data []byte) error {
+		// Only collections are buckets
+		if data != nil {
+			return nil
+		}
+
+		bucket := gen.Bucket(name)
+
+		if collection := string(name); collection != "courses" {
+			opts := []model.Option{}
+			err := decodeAll(bucket, func() interface{} { return new(model.Option) }, func(v interface{}) {
+				opts = append(opts, *v.(*model.Option))
+			})
+
+			options[collection] = opts
+
+			return err
+		}
+
+		return decodeAll(bucket, func() interface{} { return new(model.Section) }, func(v interface{}) {
+			sections = append(sections, *v.(*model.Section))
+		})
+	})
+
+	return info, options, sections, err
+}
+
+// seqKey encodes a sequence number as a key that sorts in the order documents were written
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+
+	return k
+}
+
+// put appends a document to a bucket
+func put(bucket *bolt.Bucket, v interface{}) error {
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return bucket.Put(seqKey(seq), data)
+}
+
+// decodeAll decodes every document of a bucket in the order they were written. Nested buckets are skipped
+func decodeAll(bucket *bolt.Bucket, newElem func() interface{}, fn func(v interface{})) error {
+	return bucket.ForEach(func(k, data []byte) error {
+		if data == nil {
+			return nil
+		}
+
+		elem := newElem()
+		if err := bson.Unmarshal(data, elem); err != nil {
+			return err
+		}
+
+		fn(elem)
+
+		return nil
+	})
+}
+
+// Snapshot takes a snapshot of the live generation, as it is held in memory
+func (b *Bolt) Snapshot(ctx context.Context) (Snapshot, error) {
+	return b.published.Snapshot(ctx)
+}
+
+// CountOptions counts the options of a collection matching a filter
+func (b *Bolt) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	return b.published.CountOptions(ctx, name, filter)
+}
+
+// FindOptions finds the options of a collection selected by a query
+func (b *Bolt) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	return b.published.FindOptions(ctx, name, q)
+}
+
+// OptionKeys finds the values of the sort keys of the options selected by a query
+func (b *Bolt) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	return b.published.OptionKeys(ctx, name, q)
+}
+
+// WriteOptions writes the options into the bucket of their collection in a generation
+func (b *Bolt) WriteOptions(ctx context.Context, generation int64, name string, opts []model.Option) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		gen, err := generationBucket(tx, generation)
+		if err != nil {
+			return err
+		}
+
+		// Start from an empty bucket if the options are written again
+		if err := gen.DeleteBucket([]byte(name)); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+
+		bucket, err := gen.CreateBucket([]byte(name))
+		if err != nil {
+			return err
+		}
+
+		for _, opt := range opts {
+			if err := put(bucket, opt); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// CountSections counts the sections matching a filter
+func (b *Bolt) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	return b.published.CountSections(ctx, filter)
+}
+
+// FindSections finds the sections selected by a query
+func (b *Bolt) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	return b.published.FindSections(ctx, q)
+}
+
+// SectionKeys finds the values of the sort keys of the sections selected by a query
+func (b *Bolt) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	return b.published.SectionKeys(ctx, q)
+}
+
+// CountCourses counts the courses with a section matching a filter
+func (b *Bolt) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	return b.published.CountCourses(ctx, filter)
+}
+
+// FindCourses finds the courses selected by a query
+func (b *Bolt) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	return b.published.FindCourses(ctx, q)
+}
+
+// CourseKeys finds the values of the sort keys of the courses selected by a query
+func (b *Bolt) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	return b.published.CourseKeys(ctx, q)
+}
+
+// WriteSections writes sections into the courses bucket of a generation in a single transaction. The keys of the sections
+// with an id are indexed in a nested bucket, so a section replaces the section with the same id in place
+func (b *Bolt) WriteSections(ctx context.Context, generation int64, sections []model.Section) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		gen, err := generationBucket(tx, generation)
+		if err != nil {
+			return err
+		}
+
+		courses, err := gen.CreateBucketIfNotExists([]byte("courses"))
+		if err != nil {
+			return err
+		}
+
+		index, err := courses.CreateBucketIfNotExists([]byte("index"))
+		if err != nil {
+			return err
+		}
+
+		for _, section := range sections {
+			data, err := bson.Marshal(section)
+			if err != nil {
+				return err
+			}
+
+			var k []byte
+			if section.ID != "" {
+				// Values are only valid during the transaction and must not be modified
+				k = append(k, index.Get([]byte(section.ID))...)
+			}
+
+			if len(k) == 0 {
+				seq, err := courses.NextSequence()
+				if err != nil {
+					return err
+				}
+
+				k = seqKey(seq)
+			}
+
+			if err := courses.Put(k, data); err != nil {
+				return err
+			}
+
+			if section.ID != "" {
+				if err := index.Put([]byte(section.ID), k); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+}
+
+// NewGeneration creates the bucket of a generation numbered by the sequence of the generations bucket
+func (b *Bolt) NewGeneration(ctx context.Context, run string) (model.Generation, error) {
+	var info model.Generation
+
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		gens, err := tx.CreateBucketIfNotExists([]byte("generations"))
+		if err != nil {
+			return err
+		}
+
+		seq, err := gens.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		gen, err := gens.CreateBucket(seqKey(seq))
+		if err != nil {
+			return err
+		}
+
+		info = model.Generation{Number: int64(seq), Run: run, Created: time.Now()}
+
+		data, err := bson.Marshal(info)
+		if err != nil {
+			return err
+		}
+
+		return gen.Put([]byte("info"), data)
+	})
+
+	return info, err
+}
+
+// Generations lists the generations in the generations bucket, newest first
+func (b *Bolt) Generations(ctx context.Context) ([]model.Generation, error) {
+	result := []model.Generation{}
+
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		gens := tx.Bucket([]byte("generations"))
+		if gens == nil {
+			return nil
+		}
+
+		live := liveGeneration(tx)
+
+		c := gens.Cursor()
+		for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+			info, err := readGeneration(gens.Bucket(k))
+			if err != nil {
+				return err
+			}
+
+			info.Live = info.Number == live
+			result = append(result, info)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// PublishGeneration points the meta bucket at a generation, then serves it from memory
+func (b *Bolt) PublishGeneration(ctx context.Context, number int64) error {
+	var info model.Generation
+	var options map[string][]model.Option
+	var sections []model.Section
+
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		gen, err := generationBucket(tx, number)
+		if err != nil {
+			return err
+		}
+
+		stored, err := readGeneration(gen)
+		if err != nil {
+			return err
+		}
+
+		if stored.Published.IsZero() {
+			stored.Published = time.Now()
+
+			data, err := bson.Marshal(stored)
+			if err != nil {
+				return err
+			}
+
+			if err := gen.Put([]byte("info"), data); err != nil {
+				return err
+			}
+		}
+
+		meta, err := tx.CreateBucketIfNotExists([]byte("meta"))
+		if err != nil {
+			return err
+		}
+
+		if err := meta.Put([]byte("live"), seqKey(uint64(number))); err != nil {
+			return err
+		}
+
+		info, options, sections, err = loadGeneration(tx, number)
+
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.published.serve(info, options, sections)
+}
+
+// DeleteGeneration deletes the bucket of a generation that is not live
+func (b *Bolt) DeleteGeneration(ctx context.Context, number int64) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		if number == liveGeneration(tx) {
+			return fmt.Errorf("Generation %d is live", number)
+		}
+
+		if _, err := generationBucket(tx, number); err != nil {
+			return err
+		}
+
+		return tx.Bucket([]byte("generations")).DeleteBucket(seqKey(uint64(number)))
+	})
+}
+
+// SaveRun creates or updates a run in the runs bucket, keyed by its id
+func (b *Bolt) SaveRun(ctx context.Context, run model.Run) error {
+	err := b.DB.Update(func(tx *bolt.Tx) error {
+		runs, err := tx.CreateBucketIfNotExists([]byte("runs"))
+		if err != nil {
+			return err
+		}
+
+		data, err := bson.Marshal(run)
+		if err != nil {
+			return err
+		}
+
+		return runs.Put([]byte(run.ID), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.published.SaveRun(ctx, run)
+}
+
+// Ping checks that the file is still open
+func (b *Bolt) Ping(ctx context.Context) error {
+	return b.DB.View(func(tx *bolt.Tx) error { return nil })
+}
+
+// LatestRun finds the run that wrote the live generation
+func (b *Bolt) LatestRun(ctx context.Context) (model.Run, error) {
+	return b.published.LatestRun(ctx)
+}
+
+// UnfinishedRun finds the most recently started run of a kind that is still running
+func (b *Bolt) UnfinishedRun(ctx context.Context, targeted bool) (model.Run, error) {
+	return b.published.UnfinishedRun(ctx, targeted)
+}
+
+// AcquireLease takes or renews the named lease for a holder. Only one process can open the database file, so leases are
+// only kept in memory
+func (b *Bolt) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	return b.published.AcquireLease(ctx, name, holder, ttl)
+}
+
+// ReleaseLease gives up the named lease if the holder has it
+func (b *Bolt) ReleaseLease(ctx context.Context, name string, holder string) error {
+	return b.published.ReleaseLease(ctx, name, holder)
+}
@@ -0,0 +1,124 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"uwo-tt-api/model"
+)
+
+// ErrNoGeneration is returned for a generation that does not exist
+var ErrNoGeneration = errors.New("Generation does not exist")
+
+// FindGeneration finds a stored generation by its number
+func FindGeneration(ctx context.Context, repo GenerationRepository, number int64) (model.Generation, error) {
+	gens, err := repo.Generations(ctx)
+	if err != nil {
+		return model.Generation{}, err
+	}
+
+	for _, gen := range gens {
+		if gen.Number == number {
+			return gen, nil
+		}
+	}
+
+	return model.Generation{}, fmt.Errorf("%w: %d", ErrNoGeneration, number)
+}
+
+// Rollback makes a generation that was published before live again
+func Rollback(ctx context.Context, repo GenerationRepository, number int64) error {
+	gen, err := FindGeneration(ctx, repo, number)
+	if err != nil {
+		return err
+	}
+
+	if gen.Published.IsZero() {
+		return fmt.Errorf("Generation %d was never published", number)
+	}
+
+	return repo.PublishGeneration(ctx, number)
+}
+
+// copyBatch is the number of sections copied by each write of CopySections
+const copyBatch = 500
+
+// CopySections copies the live sections matching a filter into a generation, e.g. those of subjects a targeted scrape
+// does not replace. It returns the number of sections copied
+func CopySections(ctx context.Context, repo SectionRepository, generation int64, filter Filter) (int, error) {
+	cur, err := repo.FindSections(ctx, Query{Filter: filter})
+	if err != nil {
+		return 0, err
+	}
+
+	defer cur.Close(ctx)
+
+	copied := 0
+	batch := []model.Section{}
+	for cur.Next(ctx) {
+		var section model.Section
+		if err := cur.Decode(&section); err != nil {
+			return copied, err
+		}
+
+		batch = append(batch, section)
+		if len(batch) < copyBatch {
+			continue
+		}
+
+		if err := repo.WriteSections(ctx, generation, batch); err != nil {
+			return copied, err
+		}
+
+		copied += len(batch)
+		batch = batch[:0]
+	}
+
+	if err := cur.Err(); err != nil {
+		return copied, err
+	}
+
+	if err := repo.WriteSections(ctx, generation, batch); err != nil {
+		return copied, err
+	}
+
+	return copied + len(batch), nil
+}
+
+// PruneGenerations deletes every generation except the live one and the newest published generations, keeping keep in total.
+// Unpublished generations newer than the live one are still being written and are kept too. Older unpublished generations
+// were quarantined, or left by a run stopped before a newer generation was published. They do not count towards keep and
+// are kept for inspection until they were created longer than keepQuarantined ago
+func PruneGenerations(ctx context.Context, repo GenerationRepository, keep int, keepQuarantined time.Duration) error {
+	gens, err := repo.Generations(ctx)
+	if err != nil {
+		return err
+	}
+
+	live := int64(0)
+	for _, gen := range gens {
+		if gen.Live {
+			live = gen.Number
+		}
+	}
+
+	kept := 1
+	for _, gen := range gens {
+		switch {
+		case gen.Live:
+			continue
+		case gen.Published.IsZero() && (gen.Number > live || time.Since(gen.Created) < keepQuarantined):
+			continue
+		case !gen.Published.IsZero() && kept < keep:
+			kept++
+			continue
+		}
+
+		if err := repo.DeleteGeneration(ctx, gen.Number); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
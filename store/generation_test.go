@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"uwo-tt-api/model"
+)
+
+// listedGenerations lists a fixed set of generations, recording those deleted
+type listedGenerations struct {
+	GenerationRepository
+	gens    []model.Generation
+	deleted []int64
+}
+
+func (l *listedGenerations) Generations(ctx context.Context) ([]model.Generation, error) {
+	return l.gens, nil
+}
+
+func (l *listedGenerations) DeleteGeneration(ctx context.Context, number int64) error {
+	l.deleted = append(l.deleted, number)
+	return nil
+}
+
+func TestPruneGenerations(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	// published and unpublished generations, created days ago
+	published := func(number int64, days int, live bool) model.Generation {
+		created := now.Add(-time.Duration(days) * day)
+		return model.Generation{Number: number, Created: created, Published: created.Add(time.Hour), Live: live}
+	}
+
+	unpublished := func(number int64, days int) model.Generation {
+		return model.Generation{Number: number, Created: now.Add(-time.Duration(days) * day)}
+	}
+
+	tests := []struct {
+		name    string
+		gens    []model.Generation
+		keep    int
+		deleted []int64
+	}{
+		{
+			"newest published",
+			[]model.Generation{published(5, 1, true), published(4, 2, false), published(3, 3, false), published(2, 4, false), published(1, 5, false)},
+			3, []int64{2, 1},
+		},
+		{
+			"rolled back",
+			[]model.Generation{published(3, 1, false), published(2, 2, true), published(1, 3, false)},
+			2, []int64{1},
+		},
+		{
+			"being written",
+			[]model.Generation{unpublished(3, 9), published(2, 10, true), published(1, 11, false)},
+			1, []int64{1},
+		},
+		{
+			// Quarantined generations do not take the place of published generations
+			"recently quarantined",
+			[]model.Generation{published(4, 1, true), unpublished(3, 2), unpublished(2, 3), published(1, 4, false)},
+			2, nil,
+		},
+		{
+			"quarantined long ago",
+			[]model.Generation{published(4, 1, true), unpublished(3, 6), unpublished(2, 8), published(1, 9, false)},
+			2, []int64{2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &listedGenerations{gens: test.gens}
+
+			if err := PruneGenerations(context.Background(), repo, test.keep, 7*day); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(repo.deleted, test.deleted) {
+				t.Errorf("deleted %v, want %v", repo.deleted, test.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,993 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"uwo-tt-api/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Memory stores everything in memory, answering queries with the same semantics as the db queries of Mongo
+type Memory struct {
+	mu sync.RWMutex
+
+	generations []*generation
+	live        *generation
+	runs        []model.Run
+	leases      map[string]lease
+}
+
+// lease is a lease held until it expires
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+// generation holds the documents of every collection of a generation
+type generation struct {
+	info        model.Generation
+	collections map[string][]document
+	// sections are the sections written, in the same order as the courses collection once it is built
+	sections []model.Section
+	// written finds the position of a written section by its id
+	written map[string]int
+}
+
+// document is a stored document along with its fields decoded for matching and sorting
+type document struct {
+	raw    bson.Raw
+	fields bson.D
+}
+
+// newDocument encodes a value as a document with the given id
+func newDocument(id interface{}, v interface{}) (document, error) {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return document{}, err
+	}
+
+	var elems bson.D
+	if err := bson.Unmarshal(data, &elems); err != nil {
+		return document{}, err
+	}
+
+	data, err = bson.Marshal(append(bson.D{{Key: "_id", Value: id}}, elems...))
+	if err != nil {
+		return document{}, err
+	}
+
+	doc := document{raw: data}
+	err = bson.Unmarshal(data, &doc.fields)
+
+	return doc, err
+}
+
+// newDocuments encodes values as documents with increasing ids, in the order they were published
+func newDocuments(n int, value func(i int) interface{}) ([]document, error) {
+	docs := make([]document, n)
+	for i := range docs {
+		doc, err := newDocument(int64(i+1), value(i))
+		if err != nil {
+			return nil, err
+		}
+
+		docs[i] = doc
+	}
+
+	return docs, nil
+}
+
+// NewMemory creates an empty repository
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// memorySnapshot reads a generation, or nothing if there was no live generation when it was taken
+type memorySnapshot struct {
+	m   *Memory
+	gen *generation
+}
+
+// Snapshot takes a snapshot of the live generation
+func (m *Memory) Snapshot(ctx context.Context) (Snapshot, error) {
+	return m.snapshot(), nil
+}
+
+// snapshot takes a snapshot of the live generation
+func (m *Memory) snapshot() memorySnapshot {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return memorySnapshot{m: m, gen: m.live}
+}
+
+// collection returns the documents of a collection of the generation
+func (s memorySnapshot) collection(name string) []document {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	if s.gen == nil {
+		return nil
+	}
+
+	return s.gen.collections[name]
+}
+
+// generation finds a generation by its number; the lock must be held
+func (m *Memory) generation(number int64) (*generation, error) {
+	for _, gen := range m.generations {
+		if gen.info.Number == number {
+			return gen, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Generation %d does not exist", number)
+}
+
+// CountOptions counts the live options of a collection matching a filter
+func (m *Memory) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	return m.snapshot().CountOptions(ctx, name, filter)
+}
+
+// FindOptions finds the live options of a collection selected by a query
+func (m *Memory) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	return m.snapshot().FindOptions(ctx, name, q)
+}
+
+// OptionKeys finds the values of the sort keys of the live options selected by a query
+func (m *Memory) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	return m.snapshot().OptionKeys(ctx, name, q)
+}
+
+// CountOptions counts the options of a collection matching a filter
+func (s memorySnapshot) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	return count(s.collection(name), filter), nil
+}
+
+// FindOptions finds the options of a collection selected by a query. Every field is returned as fields are only a db optimisation
+func (s memorySnapshot) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	return newMemoryCursor(selectPage(s.collection(name), q)), nil
+}
+
+// OptionKeys finds the values of the sort keys of the options selected by a query
+func (s memorySnapshot) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	return pageKeys(selectPage(s.collection(name), q), q.Sort), nil
+}
+
+// WriteOptions writes every option of a collection into a generation
+func (m *Memory) WriteOptions(ctx context.Context, generation int64, name string, opts []model.Option) error {
+	docs, err := newDocuments(len(opts), func(i int) interface{} { return opts[i] })
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gen, err := m.generation(generation)
+	if err != nil {
+		return err
+	}
+
+	gen.collections[name] = docs
+
+	return nil
+}
+
+// CountSections counts the live sections matching a filter
+func (m *Memory) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	return m.snapshot().CountSections(ctx, filter)
+}
+
+// FindSections finds the live sections selected by a query
+func (m *Memory) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	return m.snapshot().FindSections(ctx, q)
+}
+
+// SectionKeys finds the values of the sort keys of the live sections selected by a query
+func (m *Memory) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	return m.snapshot().SectionKeys(ctx, q)
+}
+
+// CountSections counts the sections matching a filter
+func (s memorySnapshot) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	return count(s.collection("courses"), filter), nil
+}
+
+// FindSections finds the sections selected by a query. Every field is returned as fields are only a db optimisation
+func (s memorySnapshot) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	return newMemoryCursor(selectPage(s.collection("courses"), q)), nil
+}
+
+// SectionKeys finds the values of the sort keys of the sections selected by a query
+func (s memorySnapshot) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	return pageKeys(selectPage(s.collection("courses"), q), q.Sort), nil
+}
+
+// WriteSections adds sections to a generation, replacing those written with the same id
+func (m *Memory) WriteSections(ctx context.Context, generation int64, sections []model.Section) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gen, err := m.generation(generation)
+	if err != nil {
+		return err
+	}
+
+	// The courses collection is built again when the generation is published
+	delete(gen.collections, "courses")
+
+	for _, section := range sections {
+		if i, ok := gen.written[section.ID]; ok && section.ID != "" {
+			gen.sections[i] = section
+			continue
+		}
+
+		gen.written[section.ID] = len(gen.sections)
+		gen.sections = append(gen.sections, section)
+	}
+
+	return nil
+}
+
+// NewGeneration starts an empty generation numbered after the newest generation
+func (m *Memory) NewGeneration(ctx context.Context, run string) (model.Generation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	number := int64(1)
+	if len(m.generations) > 0 {
+		number = m.generations[len(m.generations)-1].info.Number + 1
+	}
+
+	gen := &generation{
+		info:        model.Generation{Number: number, Run: run, Created: time.Now()},
+		collections: map[string][]document{},
+		written:     map[string]int{},
+	}
+	m.generations = append(m.generations, gen)
+
+	return gen.info, nil
+}
+
+// Generations lists the generations, newest first
+func (m *Memory) Generations(ctx context.Context) ([]model.Generation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	gens := []model.Generation{}
+	for i := len(m.generations) - 1; i >= 0; i-- {
+		info := m.generations[i].info
+		info.Live = m.generations[i] == m.live
+
+		gens = append(gens, info)
+	}
+
+	return gens, nil
+}
+
+// PublishGeneration builds the courses collection of a generation if needed, then makes it live
+func (m *Memory) PublishGeneration(ctx context.Context, number int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gen, err := m.generation(number)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := gen.collections["courses"]; !ok {
+		docs, err := newDocuments(len(gen.sections), func(i int) interface{} { return gen.sections[i] })
+		if err != nil {
+			return err
+		}
+
+		gen.collections["courses"] = docs
+	}
+
+	if gen.info.Published.IsZero() {
+		gen.info.Published = time.Now()
+	}
+
+	m.live = gen
+
+	return nil
+}
+
+// DeleteGeneration deletes a generation that is not live
+func (m *Memory) DeleteGeneration(ctx context.Context, number int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, gen := range m.generations {
+		if gen.info.Number != number {
+			continue
+		}
+
+		if gen == m.live {
+			return fmt.Errorf("Generation %d is live", number)
+		}
+
+		m.generations = append(m.generations[:i], m.generations[i+1:]...)
+		return nil
+	}
+
+	return fmt.Errorf("Generation %d does not exist", number)
+}
+
+// serve replaces every generation with a generation that was written and published elsewhere, then makes it live
+func (m *Memory) serve(info model.Generation, options map[string][]model.Option, sections []model.Section) error {
+	gen := &generation{info: info, collections: map[string][]document{}, sections: sections, written: map[string]int{}}
+
+	for name, opts := range options {
+		docs, err := newDocuments(len(opts), func(i int) interface{} { return opts[i] })
+		if err != nil {
+			return err
+		}
+
+		gen.collections[name] = docs
+	}
+
+	docs, err := newDocuments(len(sections), func(i int) interface{} { return sections[i] })
+	if err != nil {
+		return err
+	}
+
+	gen.collections["courses"] = docs
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.generations = []*generation{gen}
+	m.live = gen
+
+	return nil
+}
+
+// courseGroup is a course with the sections that matched a course query
+type courseGroup struct {
+	id       bson.D
+	sections []model.Section
+}
+
+// groupCourses groups the sections of the generation matching a filter into courses, in the order each course was
+// first scraped. Every section of the generation is returned as well
+func (s memorySnapshot) groupCourses(filter Filter) ([]*courseGroup, []model.Section) {
+	s.m.mu.RLock()
+	var docs []document
+	var sections []model.Section
+	if s.gen != nil {
+		docs, sections = s.gen.collections["courses"], s.gen.sections
+	}
+	s.m.mu.RUnlock()
+
+	groups := []*courseGroup{}
+	byID := map[model.CourseComponent]*courseGroup{}
+
+	for i, doc := range docs {
+		if !matches(doc.fields, filter) {
+			continue
+		}
+
+		section := sections[i]
+		key := model.CourseComponent{
+			Faculty: section.CourseData.Faculty,
+			Number:  section.CourseData.Number,
+			Suffix:  section.CourseData.Suffix,
+		}
+
+		group, ok := byID[key]
+		if !ok {
+			group = &courseGroup{id: bson.D{
+				{Key: "faculty", Value: key.Faculty},
+				{Key: "number", Value: key.Number},
+				{Key: "suffix", Value: key.Suffix},
+			}}
+
+			byID[key] = group
+			groups = append(groups, group)
+		}
+
+		group.sections = append(group.sections, section)
+	}
+
+	return groups, sections
+}
+
+// courseDocuments builds the course documents of a query; section sort keys are kept in the course fields named by the query
+func (s memorySnapshot) courseDocuments(q CourseQuery) ([]document, error) {
+	docs := []document{}
+	groups, all := s.groupCourses(q.Sections)
+
+	for _, group := range groups {
+		first := group.sections[0]
+
+		course := bson.D{
+			{Key: "source", Value: first.Source},
+			{Key: "time", Value: first.Time},
+			{Key: "courseData", Value: first.CourseData},
+		}
+
+		sections := bson.A{}
+		for _, section := range group.sections {
+			sections = append(sections, section.SectionData)
+		}
+
+		if q.AllSections {
+			sections = bson.A{}
+			for _, section := range all {
+				if section.CourseData == first.CourseData {
+					sections = append(sections, section.SectionData)
+				}
+			}
+		}
+
+		course = append(course, bson.E{Key: "sectionData", Value: sections})
+
+		// The value of a section field is the lowest value among the matching sections of a course, or the highest
+		for key, name := range q.sectionKeys() {
+			var value interface{}
+
+			for _, section := range group.sections {
+				doc, err := newDocument(nil, section)
+				if err != nil {
+					return nil, err
+				}
+
+				candidate := sortValue(doc.fields, key, q.Highest)
+				if value == nil || q.Highest && compare(candidate, value) > 0 || !q.Highest && compare(candidate, value) < 0 {
+					value = candidate
+				}
+			}
+
+			course = append(course, bson.E{Key: name, Value: value})
+		}
+
+		doc, err := newDocument(group.id, course)
+		if err != nil {
+			return nil, err
+		}
+
+		docs = append(docs, doc)
+	}
+
+	return docs, nil
+}
+
+// CountCourses counts the live courses with a section matching a filter
+func (m *Memory) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	return m.snapshot().CountCourses(ctx, filter)
+}
+
+// FindCourses finds the live courses selected by a query
+func (m *Memory) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	return m.snapshot().FindCourses(ctx, q)
+}
+
+// CourseKeys finds the values of the sort keys of the live courses selected by a query
+func (m *Memory) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	return m.snapshot().CourseKeys(ctx, q)
+}
+
+// CountCourses counts the courses with a section matching a filter
+func (s memorySnapshot) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	groups, _ := s.groupCourses(filter)
+	return int64(len(groups)), nil
+}
+
+// FindCourses finds the courses selected by a query
+func (s memorySnapshot) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	docs, err := s.courseDocuments(q)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMemoryCursor(selectPage(docs, q.courseQuery())), nil
+}
+
+// CourseKeys finds the values of the sort keys of the courses selected by a query
+func (s memorySnapshot) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	docs, err := s.courseDocuments(q)
+	if err != nil {
+		return nil, err
+	}
+
+	page := q.courseQuery()
+	return pageKeys(selectPage(docs, page), page.Sort), nil
+}
+
+// SaveRun creates or updates a run
+func (m *Memory) SaveRun(ctx context.Context, run model.Run) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.runs {
+		if m.runs[i].ID == run.ID {
+			m.runs[i] = run
+			return nil
+		}
+	}
+
+	m.runs = append(m.runs, run)
+
+	return nil
+}
+
+// Ping always succeeds, as the data is held in memory
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+// LatestRun finds the run that wrote the live generation
+func (m *Memory) LatestRun(ctx context.Context) (model.Run, error) {
+	return m.snapshot().Run(ctx)
+}
+
+// Run finds the run that wrote the generation
+func (s memorySnapshot) Run(ctx context.Context) (model.Run, error) {
+	s.m.mu.RLock()
+	defer s.m.mu.RUnlock()
+
+	for _, run := range s.m.runs {
+		if s.gen != nil && run.ID == s.gen.info.Run {
+			return run, nil
+		}
+	}
+
+	return model.Run{}, ErrNotFound
+}
+
+// UnfinishedRun finds the most recently started run of a kind that is still running
+func (m *Memory) UnfinishedRun(ctx context.Context, targeted bool) (model.Run, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	found := false
+	var latest model.Run
+	for _, run := range m.runs {
+		if run.Status != model.RunRunning || len(run.Targets) > 0 != targeted {
+			continue
+		}
+
+		if !found || run.Started.After(latest.Started) {
+			latest, found = run, true
+		}
+	}
+
+	if !found {
+		return latest, ErrNotFound
+	}
+
+	return latest, nil
+}
+
+// AcquireLease takes or renews the named lease for a holder, unless another holder has it
+func (m *Memory) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if held, ok := m.leases[name]; ok && held.holder != holder && now.Before(held.expires) {
+		return false, nil
+	}
+
+	if m.leases == nil {
+		m.leases = map[string]lease{}
+	}
+
+	m.leases[name] = lease{holder: holder, expires: now.Add(ttl)}
+
+	return true, nil
+}
+
+// ReleaseLease gives up the named lease if the holder has it
+func (m *Memory) ReleaseLease(ctx context.Context, name string, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.leases[name].holder == holder {
+		delete(m.leases, name)
+	}
+
+	return nil
+}
+
+// count counts the documents matching a filter
+func count(docs []document, filter Filter) int64 {
+	result := int64(0)
+	for _, doc := range docs {
+		if matches(doc.fields, filter) {
+			result++
+		}
+	}
+
+	return result
+}
+
+// selectPage filters, sorts and pages documents like a db query
+func selectPage(docs []document, q Query) []document {
+	result := []document{}
+	for _, doc := range docs {
+		if matches(doc.fields, q.Filter) {
+			result = append(result, doc)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		for _, key := range q.Sort {
+			c := compare(sortValue(result[i].fields, key, q.Desc), sortValue(result[j].fields, key, q.Desc))
+			if c != 0 {
+				return c < 0 != q.Desc
+			}
+		}
+
+		return false
+	})
+
+	if q.Skip >= int64(len(result)) {
+		return []document{}
+	}
+
+	result = result[q.Skip:]
+	if q.Limit != 0 && q.Limit < int64(len(result)) {
+		result = result[:q.Limit]
+	}
+
+	return result
+}
+
+// pageKeys reads the sort keys of documents; values that are not a single value, in or through an array, are nil
+func pageKeys(docs []document, keys []string) [][]interface{} {
+	result := [][]interface{}{}
+
+	for _, doc := range docs {
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			value := keyValue(doc.fields, strings.Split(key, "."))
+			if typeOrder(value) != arrayOrder {
+				values[i] = value
+			}
+		}
+
+		result = append(result, values)
+	}
+
+	return result
+}
+
+// keyValue finds the value at a dotted path without descending into arrays, or nil if there is none
+func keyValue(value interface{}, path []string) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+
+	if doc, ok := value.(bson.D); ok {
+		for _, e := range doc {
+			if e.Key == path[0] {
+				return keyValue(e.Value, path[1:])
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookup finds every value at a dotted path, descending into each element of arrays along the way
+func lookup(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+
+	switch v := value.(type) {
+	case bson.M:
+		if child, ok := v[path[0]]; ok {
+			return lookup(child, path[1:])
+		}
+	case bson.D:
+		for _, e := range v {
+			if e.Key == path[0] {
+				return lookup(e.Value, path[1:])
+			}
+		}
+	case bson.A:
+		result := []interface{}{}
+		for _, elem := range v {
+			result = append(result, lookup(elem, path)...)
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+// matchValues finds the values a condition on a path is compared with, including the elements of arrays at the path
+func matchValues(fields bson.D, path string) []interface{} {
+	result := []interface{}{}
+	for _, value := range lookup(fields, strings.Split(path, ".")) {
+		result = append(result, value)
+
+		if elems, ok := value.(bson.A); ok {
+			result = append(result, elems...)
+		}
+	}
+
+	return result
+}
+
+// matches reports if a document meets a filter
+func matches(fields bson.D, f Filter) bool {
+	if f.Empty() {
+		return true
+	}
+
+	results := []bool{}
+	for _, c := range f.Conditions {
+		results = append(results, matchesCondition(fields, c))
+	}
+
+	for _, nested := range f.Filters {
+		results = append(results, matches(fields, nested))
+	}
+
+	for _, result := range results {
+		if result == f.Any {
+			return f.Any
+		}
+	}
+
+	return !f.Any
+}
+
+// matchesCondition reports if any value at the path of a condition compares as required. Values of different types never compare
+func matchesCondition(fields bson.D, c Condition) bool {
+	equal := false
+
+	for _, value := range matchValues(fields, c.Field) {
+		if typeOrder(value) != typeOrder(c.Value) {
+			continue
+		}
+
+		result := compare(value, c.Value)
+
+		switch c.Op {
+		case Eq, Ne:
+			equal = equal || result == 0
+		case Gt:
+			if result > 0 {
+				return true
+			}
+		case Gte:
+			if result >= 0 {
+				return true
+			}
+		case Lt:
+			if result < 0 {
+				return true
+			}
+		case Lte:
+			if result <= 0 {
+				return true
+			}
+		}
+	}
+
+	switch c.Op {
+	case Eq:
+		return equal
+	case Ne:
+		return !equal
+	default:
+		return false
+	}
+}
+
+// sortValue finds the value a document is sorted by; the lowest element of an array, or the highest when descending
+func sortValue(fields bson.D, path string, desc bool) interface{} {
+	var result interface{}
+	found := false
+
+	for _, value := range matchValues(fields, path) {
+		if _, ok := value.(bson.A); ok {
+			continue
+		}
+
+		c := compare(value, result)
+		if !found || desc && c > 0 || !desc && c < 0 {
+			result = value
+			found = true
+		}
+	}
+
+	return result
+}
+
+// Order of types when values of different types are compared, as in MongoDB
+const (
+	nullOrder = iota
+	numberOrder
+	stringOrder
+	documentOrder
+	arrayOrder
+	objectIDOrder
+	boolOrder
+	dateOrder
+)
+
+// typeOrder returns the position of the type of a value in the order of types
+func typeOrder(v interface{}) int {
+	switch v.(type) {
+	case int, int32, int64, float64:
+		return numberOrder
+	case string:
+		return stringOrder
+	case bson.D, bson.M:
+		return documentOrder
+	case bson.A:
+		return arrayOrder
+	case primitive.ObjectID:
+		return objectIDOrder
+	case bool:
+		return boolOrder
+	case time.Time, primitive.DateTime:
+		return dateOrder
+	default:
+		return nullOrder
+	}
+}
+
+// compare orders two values like MongoDB; first by type, then by value
+func compare(a, b interface{}) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return ta - tb
+	}
+
+	switch x := a.(type) {
+	case int, int32, int64, float64:
+		return compareFloat(toFloat(x), toFloat(b))
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.D:
+		return compareDocuments(x, toD(b))
+	case bson.M:
+		return compareDocuments(toD(x), toD(b))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+
+		return len(x) - len(y)
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return strings.Compare(x.Hex(), y.Hex())
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		} else if y {
+			return -1
+		}
+
+		return 1
+	case time.Time, primitive.DateTime:
+		return compareFloat(float64(toTime(x).UnixNano()), float64(toTime(b).UnixNano()))
+	default:
+		return 0
+	}
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// compareDocuments orders documents by each field in turn
+func compareDocuments(a, b bson.D) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := strings.Compare(a[i].Key, b[i].Key); c != 0 {
+			return c
+		}
+
+		if c := compare(a[i].Value, b[i].Value); c != 0 {
+			return c
+		}
+	}
+
+	return len(a) - len(b)
+}
+
+func toFloat(v interface{}) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	case float64:
+		return n
+	}
+
+	return 0
+}
+
+// toD converts a document into an ordered document; unordered fields are ordered by name
+func toD(v interface{}) bson.D {
+	switch doc := v.(type) {
+	case bson.D:
+		return doc
+	case bson.M:
+		keys := []string{}
+		for key := range doc {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		result := bson.D{}
+		for _, key := range keys {
+			result = append(result, bson.E{Key: key, Value: doc[key]})
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func toTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case time.Time:
+		return t
+	case primitive.DateTime:
+		return time.Unix(0, int64(t)*int64(time.Millisecond))
+	}
+
+	return time.Time{}
+}
+
+// memoryCursor iterates over documents held in memory
+type memoryCursor struct {
+	docs []document
+	pos  int
+}
+
+func newMemoryCursor(docs []document) *memoryCursor {
+	return &memoryCursor{docs: docs}
+}
+
+func (c *memoryCursor) Next(ctx context.Context) bool {
+	if c.pos >= len(c.docs) {
+		return false
+	}
+
+	c.pos++
+	return true
+}
+
+func (c *memoryCursor) Decode(v interface{}) error {
+	return bson.Unmarshal(c.docs[c.pos-1].raw, v)
+}
+
+func (c *memoryCursor) Err() error {
+	return nil
+}
+
+func (c *memoryCursor) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,500 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"uwo-tt-api/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// testSections are the sections of the tests, told apart by class number
+var testSections = []model.Section{
+	testSection("a", "CS", 1026, 1, "LEC", 1, "M", "W"),
+	testSection("b", "CS", 1026, 2, "LAB", 2, "F"),
+	testSection("c", "CS", 2210, 1, "LEC", 3),
+	testSection("d", "MATH", 1600, 1, "LEC", 4, "T"),
+	testSection("", "MATH", 1600, 2, "TUT", 5, "M"),
+}
+
+func testSection(id string, faculty string, number int, section int, component string, classNumber int, days ...string) model.Section {
+	times := []model.TimeComponent{}
+	for _, day := range days {
+		times = append(times, model.TimeComponent{Day: day, StartTime: "9:30 AM", EndTime: "10:30 AM"})
+	}
+
+	return model.Section{
+		ID:          id,
+		CourseData:  model.CourseComponent{Faculty: faculty, Number: number},
+		SectionData: model.SectionComponent{Number: section, Component: component, ClassNumber: classNumber, Times: times},
+	}
+}
+
+// publishSections publishes the sections in a new generation of a repository
+func publishSections(t *testing.T, repo Repository, sections []model.Section) {
+	ctx := context.Background()
+
+	gen, err := repo.NewGeneration(ctx, "run")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.WriteSections(ctx, gen.Number, sections); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.PublishGeneration(ctx, gen.Number); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestMemory publishes the sections in a new repository
+func newTestMemory(t *testing.T, sections []model.Section) *Memory {
+	m := NewMemory()
+	publishSections(t, m, sections)
+
+	return m
+}
+
+// classNumbers reads the class numbers of the sections found by a cursor
+func classNumbers(t *testing.T, cur Cursor) []int {
+	ctx := context.Background()
+	defer cur.Close(ctx)
+
+	result := []int{}
+	for cur.Next(ctx) {
+		var section model.Section
+		if err := cur.Decode(&section); err != nil {
+			t.Fatal(err)
+		}
+
+		result = append(result, section.SectionData.ClassNumber)
+	}
+
+	return result
+}
+
+// filterTests are filters of the test sections and the class numbers of the sections they match
+var filterTests = []struct {
+	name   string
+	filter Filter
+	want   []int
+}{
+	{"empty", Filter{}, []int{1, 2, 3, 4, 5}},
+	{"eq", Filter{Conditions: []Condition{{"courseData.faculty", Eq, "CS"}}}, []int{1, 2, 3}},
+	{"gte", Filter{Conditions: []Condition{{"sectionData.number", Gte, 2}}}, []int{2, 5}},
+	{"lt", Filter{Conditions: []Condition{{"courseData.number", Lt, 2000}}}, []int{1, 2, 4, 5}},
+	{"all conditions", Filter{Conditions: []Condition{
+		{"courseData.faculty", Eq, "CS"},
+		{"sectionData.component", Eq, "LEC"},
+	}}, []int{1, 3}},
+	{"any condition", Filter{Any: true, Conditions: []Condition{
+		{"courseData.faculty", Eq, "MATH"},
+		{"sectionData.number", Eq, 2},
+	}}, []int{2, 4, 5}},
+	{"nested", And(
+		Filter{Conditions: []Condition{{"courseData.faculty", Eq, "CS"}}},
+		Filter{Any: true, Conditions: []Condition{{"sectionData.component", Eq, "LAB"}, {"courseData.number", Eq, 2210}}},
+	), []int{2, 3}},
+	{"any array element", Filter{Conditions: []Condition{{"sectionData.times.days", Eq, "W"}}}, []int{1}},
+	{"ne no array element", Filter{Conditions: []Condition{{"sectionData.times.days", Ne, "M"}}}, []int{2, 3, 4}},
+	{"ne missing field", Filter{Conditions: []Condition{{"sectionData.missing", Ne, "M"}}}, []int{1, 2, 3, 4, 5}},
+	{"eq missing field", Filter{Conditions: []Condition{{"sectionData.missing", Eq, "M"}}}, []int{}},
+	{"gt missing field", Filter{Conditions: []Condition{{"sectionData.missing", Gt, 0}}}, []int{}},
+	{"other type never compares", Filter{Conditions: []Condition{{"courseData.number", Gt, "1000"}}}, []int{}},
+	{"other type is never equal", Filter{Conditions: []Condition{{"courseData.number", Ne, "1026"}}}, []int{1, 2, 3, 4, 5}},
+	{"fraction", Filter{Conditions: []Condition{{"courseData.number", Lt, 1600.5}}}, []int{1, 2, 4, 5}},
+}
+
+func TestMemoryFilters(t *testing.T) {
+	testFilters(t, newTestMemory(t, testSections))
+}
+
+// testFilters finds the test sections published in a repository with each filter
+func testFilters(t *testing.T, repo Repository) {
+	for _, test := range filterTests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			n, err := repo.CountSections(ctx, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n != int64(len(test.want)) {
+				t.Errorf("counted %d sections, want %d", n, len(test.want))
+			}
+
+			cur, err := repo.FindSections(ctx, Query{Filter: test.filter, Sort: []string{"sectionData.classNumber"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := classNumbers(t, cur); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("found %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMemoryMissingFields(t *testing.T) {
+	m := newTestMemory(t, testSections)
+
+	tests := []struct {
+		op   Op
+		want int64
+	}{
+		// The section without an id is not equal to any id
+		{Ne, 4},
+		{Eq, 1},
+	}
+
+	for _, test := range tests {
+		n, err := m.CountSections(context.Background(), Filter{Conditions: []Condition{{"id", test.op, "a"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n != test.want {
+			t.Errorf("%s counted %d sections, want %d", test.op, n, test.want)
+		}
+	}
+}
+
+func TestMemorySortsMixedTypes(t *testing.T) {
+	values := []bson.M{
+		{"n": 1, "v": "b"},
+		{"n": 2, "v": 2},
+		{"n": 3},
+		{"n": 4, "v": true},
+		{"n": 5, "v": bson.A{3, "a"}},
+		{"n": 6, "v": nil},
+		{"n": 7, "v": 1.5},
+		{"n": 8, "v": bson.M{"x": 1}},
+	}
+
+	docs := []document{}
+	for i, value := range values {
+		doc, err := newDocument(int64(i+1), value)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		docs = append(docs, doc)
+	}
+
+	tests := []struct {
+		name string
+		desc bool
+		want []int32
+	}{
+		// Missing and null values sort first, then numbers, strings, documents and booleans. Arrays sort by their lowest element
+		{"ascending", false, []int32{3, 6, 7, 2, 5, 1, 8, 4}},
+		// Arrays sort by their highest element when descending; ties keep their order
+		{"descending", true, []int32{4, 8, 1, 5, 2, 7, 3, 6}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := []int32{}
+			for _, doc := range selectPage(docs, Query{Sort: []string{"v"}, Desc: test.desc}) {
+				got = append(got, doc.raw.Lookup("n").Int32())
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("sorted %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// TestMemoryKeysetWindows pages through the sections by the sort keys of the last section of each page, as the API does
+func TestMemoryKeysetWindows(t *testing.T) {
+	testKeysetWindows(t, newTestMemory(t, testSections))
+}
+
+// testKeysetWindows pages through the test sections published in a repository
+func testKeysetWindows(t *testing.T, repo Repository) {
+	tests := []struct {
+		name string
+		sort []string
+		desc bool
+		want []int
+	}{
+		{"ascending", []string{"courseData.number", "_id"}, false, []int{1, 2, 4, 5, 3}},
+		{"descending", []string{"courseData.number", "_id"}, true, []int{3, 5, 4, 2, 1}},
+		{"by section", []string{"sectionData.number", "courseData.faculty", "_id"}, false, []int{1, 3, 4, 2, 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			op := Gt
+			if test.desc {
+				op = Lt
+			}
+
+			got := []int{}
+			after := Filter{}
+
+			for page := 0; page < len(testSections); page++ {
+				q := Query{Filter: after, Sort: test.sort, Desc: test.desc, Limit: 2}
+
+				keys, err := repo.SectionKeys(ctx, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if len(keys) == 0 {
+					break
+				}
+
+				// The sections of a window are those from its first key up to its last
+				window := q
+				window.Filter = And(after, keyset(test.sort, keys[0], map[Op]Op{Gt: Gte, Lt: Lte}[op]))
+				window.Limit = int64(len(keys))
+
+				cur, err := repo.FindSections(ctx, window)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				found := classNumbers(t, cur)
+				if len(found) != len(keys) {
+					t.Fatalf("window found %v for %d keys", found, len(keys))
+				}
+
+				got = append(got, found...)
+				after = keyset(test.sort, keys[len(keys)-1], op)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("paged %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+// keyset matches the documents past the given sort keys, or at them for an inclusive op
+func keyset(sort []string, values []interface{}, op Op) Filter {
+	strict := map[Op]Op{Gte: Gt, Lte: Lt}[op]
+	if strict == "" {
+		strict = op
+	}
+
+	result := Filter{Any: true}
+	for i, key := range sort {
+		f := Filter{}
+		for j := 0; j < i; j++ {
+			f.Conditions = append(f.Conditions, Condition{sort[j], Eq, values[j]})
+		}
+
+		last := strict
+		if i == len(sort)-1 {
+			last = op
+		}
+
+		f.Conditions = append(f.Conditions, Condition{key, last, values[i]})
+		result.Filters = append(result.Filters, f)
+	}
+
+	return result
+}
+
+func TestMemoryKeysOfArrays(t *testing.T) {
+	testKeysOfArrays(t, newTestMemory(t, testSections))
+}
+
+// testKeysOfArrays reads sort keys through an array of the test sections published in a repository, which have no value
+func testKeysOfArrays(t *testing.T, repo Repository) {
+	keys, err := repo.SectionKeys(context.Background(), Query{Sort: []string{"sectionData.times.days", "sectionData.classNumber"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(keys) != len(testSections) {
+		t.Fatalf("found %d keys, want %d", len(keys), len(testSections))
+	}
+
+	for _, key := range keys {
+		if key[0] != nil || key[1] == nil {
+			t.Errorf("keys are %v, want no value for the array", key)
+		}
+	}
+}
+
+func TestMemorySnapshot(t *testing.T) {
+	testSnapshot(t, newTestMemory(t, testSections))
+}
+
+// testSnapshot reads a snapshot of the test sections published in a repository while another generation is published
+func testSnapshot(t *testing.T, repo Repository) {
+	ctx := context.Background()
+
+	for _, id := range []string{"run", "next"} {
+		if err := repo.SaveRun(ctx, model.Run{ID: id, Status: model.RunPublished, Problems: []string{}, Checkpoints: []model.Checkpoint{}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gens, err := repo.Generations(ctx)
+	if err != nil || len(gens) == 0 || !gens[0].Live {
+		t.Fatalf("found generations %v with %v", gens, err)
+	}
+
+	snapshot, err := repo.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	next, err := repo.NewGeneration(ctx, "next")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.WriteSections(ctx, next.Number, []model.Section{testSection("z", "BIO", 1001, 1, "LEC", 9)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.PublishGeneration(ctx, next.Number); err != nil {
+		t.Fatal(err)
+	}
+
+	// The generation published last is served from then on
+	if n, err := repo.CountSections(ctx, Filter{}); err != nil || n != 1 {
+		t.Errorf("counted %d live sections with %v, want 1", n, err)
+	}
+
+	// The snapshot still reads every query from the generation live when it was taken
+	if n, err := snapshot.CountSections(ctx, Filter{}); err != nil || n != int64(len(testSections)) {
+		t.Errorf("counted %d sections of the snapshot with %v, want %d", n, err, len(testSections))
+	}
+
+	cur, err := snapshot.FindSections(ctx, Query{Sort: []string{"sectionData.classNumber"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := classNumbers(t, cur), []int{1, 2, 3, 4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("found %v in the snapshot, want %v", got, want)
+	}
+
+	if n, err := snapshot.CountCourses(ctx, Filter{}); err != nil || n != 3 {
+		t.Errorf("counted %d courses of the snapshot with %v, want 3", n, err)
+	}
+
+	if run, err := snapshot.Run(ctx); err != nil || run.ID != "run" {
+		t.Errorf("run of the snapshot is %+v with %v, want run", run, err)
+	}
+
+	if run, err := repo.LatestRun(ctx); err != nil || run.ID != "next" {
+		t.Errorf("latest run is %+v with %v, want next", run, err)
+	}
+
+	// Roll back, so the test sections are live again
+	if err := repo.PublishGeneration(ctx, gens[0].Number); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemoryCourses(t *testing.T) {
+	testCourses(t, newTestMemory(t, testSections))
+}
+
+// testCourses groups the test sections published in a repository into courses
+func testCourses(t *testing.T, repo Repository) {
+	tests := []struct {
+		name     string
+		q        CourseQuery
+		total    int64
+		want     [][]int
+		wantKeys [][]interface{}
+	}{
+		{
+			"scraped order",
+			CourseQuery{},
+			3, [][]int{{1, 2}, {3}, {4, 5}}, nil,
+		},
+		{
+			"matching sections",
+			CourseQuery{Sections: Filter{Conditions: []Condition{{"sectionData.component", Eq, "LEC"}}}},
+			3, [][]int{{1}, {3}, {4}}, nil,
+		},
+		{
+			"all sections",
+			CourseQuery{Sections: Filter{Conditions: []Condition{{"sectionData.component", Eq, "LAB"}}}, AllSections: true},
+			1, [][]int{{1, 2}}, nil,
+		},
+		{
+			"lowest section",
+			CourseQuery{Query: Query{Sort: []string{"sectionData.classNumber"}, Limit: 2}},
+			3, [][]int{{1, 2}, {3}}, [][]interface{}{{int32(1)}, {int32(3)}},
+		},
+		{
+			"highest section",
+			CourseQuery{Query: Query{Sort: []string{"sectionData.classNumber"}, Desc: true}, Highest: true},
+			3, [][]int{{4, 5}, {3}, {1, 2}}, [][]interface{}{{int32(5)}, {int32(3)}, {int32(2)}},
+		},
+		{
+			"filtered by section",
+			CourseQuery{Query: Query{Filter: Filter{Conditions: []Condition{{"sectionData.classNumber", Gt, 2}}}, Sort: []string{"sectionData.classNumber"}}},
+			3, [][]int{{3}, {4, 5}}, [][]interface{}{{int32(3)}, {int32(4)}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			total, err := repo.CountCourses(ctx, test.q.Sections)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if total != test.total {
+				t.Errorf("counted %d courses, want %d", total, test.total)
+			}
+
+			cur, err := repo.FindCourses(ctx, test.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := [][]int{}
+			for cur.Next(ctx) {
+				var course model.Course
+				if err := cur.Decode(&course); err != nil {
+					t.Fatal(err)
+				}
+
+				sections := []int{}
+				for _, section := range course.SectionData {
+					sections = append(sections, section.ClassNumber)
+				}
+
+				got = append(got, sections)
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("found %v, want %v", got, test.want)
+			}
+
+			if test.wantKeys == nil {
+				return
+			}
+
+			keys, err := repo.CourseKeys(ctx, test.q)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Numbers are compared by value, as backends read them as integers of different sizes
+			if fmt.Sprint(keys) != fmt.Sprint(test.wantKeys) {
+				t.Errorf("keys are %v, want %v", keys, test.wantKeys)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package store
+
+// migrations are the versioned schema changes of the Postgres repository, applied in order; the version of a migration
+// is its position starting at 1. An applied migration must never change, schema changes are made by adding a new one.
+// Text columns use the C collation so they sort by byte like MongoDB strings
+var migrations = []string{
+	// 1: options, courses, sections with their meeting times and scrape runs
+	`CREATE TABLE options (
+		id bigserial PRIMARY KEY,
+		collection text COLLATE "C" NOT NULL,
+		source_title text COLLATE "C" NOT NULL,
+		source_year text COLLATE "C" NOT NULL,
+		source_url text COLLATE "C" NOT NULL,
+		added timestamptz NOT NULL,
+		value text COLLATE "C" NOT NULL,
+		text text COLLATE "C" NOT NULL
+	);
+
+	CREATE INDEX options_collection ON options (collection, id);
+
+	CREATE TABLE courses (
+		id bigserial PRIMARY KEY,
+		faculty text COLLATE "C" NOT NULL,
+		number integer NOT NULL,
+		suffix text COLLATE "C" NOT NULL,
+		name text COLLATE "C" NOT NULL,
+		description text COLLATE "C" NOT NULL
+	);
+
+	CREATE UNIQUE INDEX courses_data ON courses (faculty, number, suffix, name, md5(description));
+
+	CREATE TABLE sections (
+		id bigserial PRIMARY KEY,
+		course_id bigint NOT NULL REFERENCES courses,
+		published boolean NOT NULL DEFAULT false,
+		source_title text COLLATE "C" NOT NULL,
+		source_year text COLLATE "C" NOT NULL,
+		source_url text COLLATE "C" NOT NULL,
+		added timestamptz NOT NULL,
+		number integer NOT NULL,
+		component text COLLATE "C" NOT NULL,
+		class_number integer NOT NULL,
+		location text COLLATE "C" NOT NULL,
+		instructor text COLLATE "C" NOT NULL,
+		requisites text COLLATE "C" NOT NULL,
+		status text COLLATE "C" NOT NULL,
+		campus text COLLATE "C" NOT NULL,
+		delivery text COLLATE "C" NOT NULL
+	);
+
+	CREATE INDEX sections_published ON sections (published, id);
+	CREATE INDEX sections_course ON sections (course_id, number, component);
+	CREATE INDEX sections_class_number ON sections (class_number);
+
+	CREATE TABLE meeting_times (
+		section_id bigint NOT NULL REFERENCES sections ON DELETE CASCADE,
+		position integer NOT NULL,
+		days text COLLATE "C" NOT NULL,
+		start_time text COLLATE "C" NOT NULL,
+		end_time text COLLATE "C" NOT NULL,
+		PRIMARY KEY (section_id, position)
+	);
+
+	CREATE TABLE runs (
+		id text PRIMARY KEY,
+		started timestamptz NOT NULL,
+		finished timestamptz NOT NULL,
+		status text NOT NULL,
+		subjects integer NOT NULL,
+		sections integer NOT NULL
+	);
+
+	CREATE INDEX runs_finished ON runs (status, finished);`,
+
+	// 2: numbered generations of options and sections replace the published flag of sections. The published data becomes
+	// the first generation, written by the latest published run
+	`CREATE TABLE generations (
+		number bigserial PRIMARY KEY,
+		run text COLLATE "C" NOT NULL,
+		created timestamptz NOT NULL DEFAULT now(),
+		published timestamptz
+	);
+
+	CREATE TABLE live_generation (
+		id boolean PRIMARY KEY DEFAULT true CHECK (id),
+		generation bigint NOT NULL REFERENCES generations
+	);
+
+	INSERT INTO generations (run, published)
+		SELECT coalesce((SELECT id FROM runs WHERE status = 'published' ORDER BY finished DESC LIMIT 1), ''), now()
+		WHERE EXISTS (SELECT 1 FROM options) OR EXISTS (SELECT 1 FROM sections WHERE published);
+
+	INSERT INTO live_generation (generation) SELECT number FROM generations;
+
+	ALTER TABLE runs ADD COLUMN generation bigint NOT NULL DEFAULT 0;
+	UPDATE runs r SET generation = g.number FROM generations g WHERE g.run = r.id;
+
+	ALTER TABLE options ADD COLUMN generation bigint REFERENCES generations ON DELETE CASCADE;
+	UPDATE options SET generation = (SELECT generation FROM live_generation);
+	ALTER TABLE options ALTER COLUMN generation SET NOT NULL;
+
+	DROP INDEX options_collection;
+	CREATE INDEX options_collection ON options (generation, collection, id);
+
+	DELETE FROM sections WHERE NOT published;
+	ALTER TABLE sections ADD COLUMN generation bigint REFERENCES generations ON DELETE CASCADE;
+	UPDATE sections SET generation = (SELECT generation FROM live_generation);
+	ALTER TABLE sections ALTER COLUMN generation SET NOT NULL;
+	ALTER TABLE sections DROP COLUMN published;
+
+	CREATE INDEX sections_generation ON sections (generation, id);
+	DELETE FROM courses c WHERE NOT EXISTS (SELECT 1 FROM sections s WHERE s.course_id = c.id);`,
+
+	// 3: the validation checks failed by quarantined runs
+	`ALTER TABLE runs ADD COLUMN problems jsonb NOT NULL DEFAULT '[]';`,
+
+	// 4: the ids of sections, by which a section is replaced when it is written again. Sections without one have no key
+	`ALTER TABLE sections ADD COLUMN key text COLLATE "C";
+
+	CREATE UNIQUE INDEX sections_key ON sections (generation, key);`,
+
+	// 5: the subjects written by a run, from which an interrupted run resumes
+	`ALTER TABLE runs ADD COLUMN checkpoints jsonb NOT NULL DEFAULT '[]';`,
+
+	// 6: the subjects scraped by targeted runs
+	`ALTER TABLE runs ADD COLUMN targets jsonb NOT NULL DEFAULT '[]';`,
+
+	// 7: leases that let one instance at a time scrape
+	`CREATE TABLE leases (
+		name text PRIMARY KEY,
+		holder text NOT NULL,
+		expires timestamptz NOT NULL
+	);`,
+}
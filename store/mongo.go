@@ -0,0 +1,724 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"uwo-tt-api/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo stores everything in a MongoDB database. Each generation has its own collections, named after the collection and
+// the number of the generation. The number of the live generation is kept in the meta collection, so every collection is
+// published at once by updating a single document
+type Mongo struct {
+	DB *mongo.Database
+}
+
+// storedGeneration is a generation along with the names of the option collections written into it
+type storedGeneration struct {
+	model.Generation `bson:",inline"`
+	Collections      []string `bson:"collections"`
+}
+
+// NewMongo creates a repository backed by a database
+func NewMongo(db *mongo.Database) *Mongo {
+	return &Mongo{DB: db}
+}
+
+// generationCollection names the collection of a generation
+func generationCollection(name string, generation int64) string {
+	return fmt.Sprintf("%s_g%d", name, generation)
+}
+
+// FilterDocument converts a filter into a db query document
+func FilterDocument(f Filter) bson.M {
+	clauses := bson.A{}
+
+	for _, c := range f.Conditions {
+		clauses = append(clauses, bson.M{c.Field: bson.M{"$" + string(c.Op): c.Value}})
+	}
+
+	for _, nested := range f.Filters {
+		if !nested.Empty() {
+			clauses = append(clauses, FilterDocument(nested))
+		}
+	}
+
+	if len(clauses) == 0 {
+		return bson.M{}
+	}
+
+	if f.Any {
+		return bson.M{"$or": clauses}
+	}
+
+	return bson.M{"$and": clauses}
+}
+
+// sortDocument converts the sort keys of a query into a db sort document
+func sortDocument(q Query) bson.D {
+	dir := 1
+	if q.Desc {
+		dir = -1
+	}
+
+	sort := bson.D{}
+	for _, key := range q.Sort {
+		sort = append(sort, bson.E{Key: key, Value: dir})
+	}
+
+	return sort
+}
+
+// projectionDocument converts dotted paths into a db projection document
+func projectionDocument(fields []string) bson.M {
+	projection := bson.M{}
+	for _, field := range fields {
+		projection[field] = 1
+	}
+
+	return projection
+}
+
+// findOptions converts the sort, page and fields of a query into find options
+func findOptions(q Query) *options.FindOptions {
+	result := options.Find()
+
+	if len(q.Sort) > 0 {
+		result.SetSort(sortDocument(q))
+	}
+
+	if q.Skip != 0 {
+		result.SetSkip(q.Skip)
+	}
+
+	if q.Limit != 0 {
+		result.SetLimit(q.Limit)
+	}
+
+	if q.Fields != nil {
+		result.SetProjection(projectionDocument(q.Fields))
+	}
+
+	return result
+}
+
+// liveGeneration finds the number of the live generation, or 0 if nothing was published since generations were introduced
+func (m *Mongo) liveGeneration(ctx context.Context) (int64, error) {
+	live := struct {
+		Generation int64 `bson:"generation"`
+	}{}
+
+	err := m.DB.Collection("meta").FindOne(ctx, bson.M{"_id": "live"}).Decode(&live)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+
+	return live.Generation, err
+}
+
+// mongoSnapshot reads the collections of a generation
+type mongoSnapshot struct {
+	m          *Mongo
+	generation int64
+}
+
+// Snapshot takes a snapshot of the live generation
+func (m *Mongo) Snapshot(ctx context.Context) (Snapshot, error) {
+	return m.snapshot(ctx)
+}
+
+// snapshot takes a snapshot of the live generation
+func (m *Mongo) snapshot(ctx context.Context) (mongoSnapshot, error) {
+	live, err := m.liveGeneration(ctx)
+	return mongoSnapshot{m: m, generation: live}, err
+}
+
+// collection returns the collection of a name in the generation. Data published before generations were introduced is
+// kept in a collection of the name itself, which stays live until a generation is published
+func (s mongoSnapshot) collection(name string) *mongo.Collection {
+	if s.generation == 0 {
+		return s.m.DB.Collection(name)
+	}
+
+	return s.m.DB.Collection(generationCollection(name, s.generation))
+}
+
+// count counts the documents of a collection matching a filter
+func (s mongoSnapshot) count(ctx context.Context, name string, filter Filter) (int64, error) {
+	return s.collection(name).CountDocuments(ctx, FilterDocument(filter))
+}
+
+// find finds the documents of a collection selected by a query
+func (s mongoSnapshot) find(ctx context.Context, name string, q Query) (Cursor, error) {
+	return s.collection(name).Find(ctx, FilterDocument(q.Filter), findOptions(q))
+}
+
+// keys finds the values of the sort keys of the documents of a collection selected by a query
+func (s mongoSnapshot) keys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	collection := s.collection(name)
+
+	// Only the sort keys are needed to find the boundaries of a page
+	q.Fields = q.Sort
+
+	cur, err := collection.Find(ctx, FilterDocument(q.Filter), findOptions(q))
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeys(ctx, cur, q.Sort)
+}
+
+// CountOptions counts the live options of a collection matching a filter
+func (m *Mongo) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountOptions(ctx, name, filter)
+}
+
+// CountOptions counts the options of a collection matching a filter
+func (s mongoSnapshot) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	return s.count(ctx, name, filter)
+}
+
+// FindOptions finds the live options of a collection selected by a query
+func (m *Mongo) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindOptions(ctx, name, q)
+}
+
+// FindOptions finds the options of a collection selected by a query
+func (s mongoSnapshot) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	return s.find(ctx, name, q)
+}
+
+// OptionKeys finds the values of the sort keys of the live options selected by a query
+func (m *Mongo) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.OptionKeys(ctx, name, q)
+}
+
+// OptionKeys finds the values of the sort keys of the options selected by a query
+func (s mongoSnapshot) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	return s.keys(ctx, name, q)
+}
+
+// WriteOptions writes the options into the collection of a generation
+func (m *Mongo) WriteOptions(ctx context.Context, generation int64, name string, opts []model.Option) error {
+	res, err := m.DB.Collection("generations").UpdateOne(ctx, bson.M{"_id": generation},
+		bson.M{"$addToSet": bson.M{"collections": name}})
+	if err != nil {
+		return err
+	}
+
+	if res.MatchedCount == 0 {
+		return fmt.Errorf("Generation %d does not exist", generation)
+	}
+
+	collection := m.DB.Collection(generationCollection(name, generation))
+
+	// Delete all existing documents if the options are written again
+	if _, err := collection.DeleteMany(ctx, bson.M{}); err != nil {
+		return err
+	}
+
+	if len(opts) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, len(opts))
+	for i := range opts {
+		docs[i] = opts[i]
+	}
+
+	_, err = collection.InsertMany(ctx, docs)
+
+	return err
+}
+
+// CountSections counts the live sections matching a filter
+func (m *Mongo) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountSections(ctx, filter)
+}
+
+// CountSections counts the sections matching a filter
+func (s mongoSnapshot) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	return s.count(ctx, "courses", filter)
+}
+
+// FindSections finds the live sections selected by a query
+func (m *Mongo) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindSections(ctx, q)
+}
+
+// FindSections finds the sections selected by a query
+func (s mongoSnapshot) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	return s.find(ctx, "courses", q)
+}
+
+// SectionKeys finds the values of the sort keys of the live sections selected by a query
+func (m *Mongo) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SectionKeys(ctx, q)
+}
+
+// SectionKeys finds the values of the sort keys of the sections selected by a query
+func (s mongoSnapshot) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	return s.keys(ctx, "courses", q)
+}
+
+// WriteSections writes sections into the courses collection of a generation with a single ordered bulk write.
+// A section with an id replaces the document with the same id, or is inserted if there is none
+func (m *Mongo) WriteSections(ctx context.Context, generation int64, sections []model.Section) error {
+	if len(sections) == 0 {
+		return nil
+	}
+
+	writes := make([]mongo.WriteModel, len(sections))
+	for i, section := range sections {
+		if section.ID == "" {
+			writes[i] = mongo.NewInsertOneModel().SetDocument(section)
+			continue
+		}
+
+		writes[i] = mongo.NewReplaceOneModel().SetFilter(bson.M{"id": section.ID}).SetReplacement(section).SetUpsert(true)
+	}
+
+	_, err := m.DB.Collection(generationCollection("courses", generation)).BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(true))
+
+	return err
+}
+
+// NewGeneration records a generation numbered by the sequence in the meta collection
+func (m *Mongo) NewGeneration(ctx context.Context, run string) (model.Generation, error) {
+	seq := struct {
+		Seq int64 `bson:"seq"`
+	}{}
+
+	after := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	err := m.DB.Collection("meta").FindOneAndUpdate(ctx, bson.M{"_id": "generations"},
+		bson.M{"$inc": bson.M{"seq": 1}}, after).Decode(&seq)
+	if err != nil {
+		return model.Generation{}, err
+	}
+
+	gen := storedGeneration{
+		Generation:  model.Generation{Number: seq.Seq, Run: run, Created: time.Now()},
+		Collections: []string{"courses"},
+	}
+
+	if _, err := m.DB.Collection("generations").InsertOne(ctx, gen); err != nil {
+		return model.Generation{}, err
+	}
+
+	// Sections are replaced by their id as they are written, so no two sections may share one. Sections without an id leave
+	// out the field, so the index is sparse for them to always be added
+	index := mongo.IndexModel{Keys: bson.M{"id": 1}, Options: options.Index().SetUnique(true).SetSparse(true)}
+	_, err = m.DB.Collection(generationCollection("courses", gen.Number)).Indexes().CreateOne(ctx, index)
+
+	return gen.Generation, err
+}
+
+// Generations lists the generations in the generations collection, newest first
+func (m *Mongo) Generations(ctx context.Context) ([]model.Generation, error) {
+	live, err := m.liveGeneration(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cur, err := m.DB.Collection("generations").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": -1}))
+	if err != nil {
+		return nil, err
+	}
+
+	defer cur.Close(ctx)
+
+	gens := []model.Generation{}
+	for cur.Next(ctx) {
+		var gen model.Generation
+		if err := cur.Decode(&gen); err != nil {
+			return nil, err
+		}
+
+		gen.Live = gen.Number == live
+		gens = append(gens, gen)
+	}
+
+	return gens, cur.Err()
+}
+
+// PublishGeneration points the meta collection at a generation
+func (m *Mongo) PublishGeneration(ctx context.Context, number int64) error {
+	var gen model.Generation
+	err := m.DB.Collection("generations").FindOne(ctx, bson.M{"_id": number}).Decode(&gen)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("Generation %d does not exist", number)
+	} else if err != nil {
+		return err
+	}
+
+	if gen.Published.IsZero() {
+		_, err := m.DB.Collection("generations").UpdateOne(ctx, bson.M{"_id": number},
+			bson.M{"$set": bson.M{"published": time.Now()}})
+		if err != nil {
+			return err
+		}
+	}
+
+	upsert := options.Update().SetUpsert(true)
+	_, err = m.DB.Collection("meta").UpdateOne(ctx, bson.M{"_id": "live"},
+		bson.M{"$set": bson.M{"generation": number}}, upsert)
+
+	return err
+}
+
+// DeleteGeneration drops the collections of a generation that is not live
+func (m *Mongo) DeleteGeneration(ctx context.Context, number int64) error {
+	live, err := m.liveGeneration(ctx)
+	if err != nil {
+		return err
+	}
+
+	if number == live {
+		return fmt.Errorf("Generation %d is live", number)
+	}
+
+	var gen storedGeneration
+	err = m.DB.Collection("generations").FindOne(ctx, bson.M{"_id": number}).Decode(&gen)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("Generation %d does not exist", number)
+	} else if err != nil {
+		return err
+	}
+
+	for _, name := range gen.Collections {
+		if err := m.DB.Collection(generationCollection(name, number)).Drop(ctx); err != nil {
+			return err
+		}
+	}
+
+	_, err = m.DB.Collection("generations").DeleteOne(ctx, bson.M{"_id": number})
+
+	return err
+}
+
+// readKeys reads the sort keys of every remaining document of a db cursor and closes it
+func readKeys(ctx context.Context, cur *mongo.Cursor, keys []string) ([][]interface{}, error) {
+	defer cur.Close(ctx)
+
+	result := [][]interface{}{}
+	for cur.Next(ctx) {
+		// Current is only valid until the next call to Next so it must be copied
+		doc := append(bson.Raw{}, cur.Current...)
+
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			value, err := doc.LookupErr(strings.Split(key, ".")...)
+			if err == nil && value.Type != bson.TypeArray {
+				values[i] = value
+			}
+		}
+
+		result = append(result, values)
+	}
+
+	return result, cur.Err()
+}
+
+// courseID groups sections by the course information that identifies a course
+var courseID = bson.D{
+	{Key: "faculty", Value: "$courseData.faculty"},
+	{Key: "number", Value: "$courseData.number"},
+	{Key: "suffix", Value: "$courseData.suffix"},
+}
+
+// allSections replaces the matching sections of each course with all of its sections in the courses collection
+func allSections(courses string) bson.A {
+	return bson.A{
+		bson.M{"$lookup": bson.M{
+			"from":         courses,
+			"localField":   "courseData",
+			"foreignField": "courseData",
+			"as":           "sections",
+		}},
+		bson.M{"$addFields": bson.M{"sectionData": "$sections.sectionData"}},
+		bson.M{"$project": bson.M{"sections": 0}},
+	}
+}
+
+// coursePipeline builds the aggregation stages that group the sections of a query into courses and select a page of them.
+// The pipeline returns the grouped courses with their query, whose sort keys refer to the fields of the grouped courses
+func coursePipeline(q CourseQuery) (bson.A, Query) {
+	group := bson.M{
+		"_id":        courseID,
+		"source":     bson.M{"$first": "$source"},
+		"time":       bson.M{"$first": "$time"},
+		"courseData": bson.M{"$first": "$courseData"},
+	}
+
+	if !q.AllSections {
+		group["sectionData"] = bson.M{"$push": "$sectionData"}
+	}
+
+	accumulator := "$min"
+	if q.Highest {
+		accumulator = "$max"
+	}
+
+	for key, name := range q.sectionKeys() {
+		group[name] = bson.M{accumulator: "$" + key}
+	}
+
+	page := q.courseQuery()
+
+	pipeline := bson.A{
+		bson.M{"$match": FilterDocument(q.Sections)},
+		// Sections are pushed in the order they were scraped
+		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$group": group},
+	}
+
+	if !page.Filter.Empty() {
+		pipeline = append(pipeline, bson.M{"$match": FilterDocument(page.Filter)})
+	}
+
+	if len(page.Sort) > 0 {
+		pipeline = append(pipeline, bson.M{"$sort": sortDocument(page)})
+	}
+
+	if page.Skip != 0 {
+		pipeline = append(pipeline, bson.M{"$skip": page.Skip})
+	}
+
+	if page.Limit != 0 {
+		pipeline = append(pipeline, bson.M{"$limit": page.Limit})
+	}
+
+	return pipeline, page
+}
+
+// CountCourses counts the live courses with a section matching a filter
+func (m *Mongo) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountCourses(ctx, filter)
+}
+
+// CountCourses counts the courses with a section matching a filter
+func (s mongoSnapshot) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	pipeline := bson.A{
+		bson.M{"$match": FilterDocument(filter)},
+		bson.M{"$group": bson.M{"_id": courseID}},
+		bson.M{"$count": "total"},
+	}
+
+	courses := s.collection("courses")
+
+	cur, err := courses.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+
+	defer cur.Close(ctx)
+
+	count := struct {
+		Total int64 `bson:"total"`
+	}{}
+
+	// No courses match when nothing is counted
+	if cur.Next(ctx) {
+		if err := cur.Decode(&count); err != nil {
+			return 0, err
+		}
+	}
+
+	return count.Total, cur.Err()
+}
+
+// FindCourses finds the live courses selected by a query
+func (m *Mongo) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindCourses(ctx, q)
+}
+
+// FindCourses finds the courses selected by a query
+func (s mongoSnapshot) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	courses := s.collection("courses")
+
+	pipeline, _ := coursePipeline(q)
+
+	if q.AllSections {
+		pipeline = append(pipeline, allSections(courses.Name())...)
+	}
+
+	if q.Fields != nil {
+		pipeline = append(pipeline, bson.M{"$project": projectionDocument(q.Fields)})
+	}
+
+	return courses.Aggregate(ctx, pipeline)
+}
+
+// CourseKeys finds the values of the sort keys of the live courses selected by a query
+func (m *Mongo) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.CourseKeys(ctx, q)
+}
+
+// CourseKeys finds the values of the sort keys of the courses selected by a query
+func (s mongoSnapshot) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	courses := s.collection("courses")
+
+	pipeline, page := coursePipeline(q)
+	pipeline = append(pipeline, bson.M{"$project": projectionDocument(page.Sort)})
+
+	cur, err := courses.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeys(ctx, cur, page.Sort)
+}
+
+// SaveRun creates or updates a run in the runs collection
+func (m *Mongo) SaveRun(ctx context.Context, run model.Run) error {
+	upsert := options.Replace().SetUpsert(true)
+	_, err := m.DB.Collection("runs").ReplaceOne(ctx, bson.M{"_id": run.ID}, run, upsert)
+
+	return err
+}
+
+// Ping checks that the database can be reached
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.DB.Client().Ping(ctx, nil)
+}
+
+// LatestRun finds the run that wrote the live generation
+func (m *Mongo) LatestRun(ctx context.Context) (model.Run, error) {
+	s, err := m.snapshot(ctx)
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	return s.Run(ctx)
+}
+
+// Run finds the run that wrote the generation. Before a generation is published, it is the most recent scrape run that
+// published its data
+func (s mongoSnapshot) Run(ctx context.Context) (model.Run, error) {
+	var run model.Run
+
+	filter := bson.M{"status": model.RunPublished}
+	if s.generation != 0 {
+		var gen model.Generation
+		if err := s.m.DB.Collection("generations").FindOne(ctx, bson.M{"_id": s.generation}).Decode(&gen); err != nil {
+			return run, err
+		}
+
+		filter = bson.M{"_id": gen.Run}
+	}
+
+	findOptions := options.FindOne().SetSort(bson.M{"finished": -1})
+	err := s.m.DB.Collection("runs").FindOne(ctx, filter, findOptions).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return run, ErrNotFound
+	}
+
+	return run, err
+}
+
+// UnfinishedRun finds the most recently started run of a kind that is still running. The targets of a run are left out
+// unless it has some, so only targeted runs have the field
+func (m *Mongo) UnfinishedRun(ctx context.Context, targeted bool) (model.Run, error) {
+	var run model.Run
+
+	filter := bson.M{"status": model.RunRunning, "targets": bson.M{"$exists": targeted}}
+	findOptions := options.FindOne().SetSort(bson.M{"started": -1})
+	err := m.DB.Collection("runs").FindOne(ctx, filter, findOptions).Decode(&run)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return run, ErrNotFound
+	}
+
+	return run, err
+}
+
+// AcquireLease takes or renews the named lease for a holder in the leases collection. The lease document is only matched if
+// the holder has it or it expired, so while another holder has it the upsert fails on the duplicate id
+func (m *Mongo) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+
+	filter := bson.M{"_id": name, "$or": bson.A{bson.M{"holder": holder}, bson.M{"expires": bson.M{"$lte": now}}}}
+	update := bson.M{"$set": bson.M{"holder": holder, "expires": now.Add(ttl)}}
+
+	_, err := m.DB.Collection("leases").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if isDuplicateKey(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ReleaseLease gives up the named lease if the holder has it
+func (m *Mongo) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := m.DB.Collection("leases").DeleteOne(ctx, bson.M{"_id": name, "holder": holder})
+
+	return err
+}
+
+// isDuplicateKey reports if a write failed because a document with the same unique key exists
+func isDuplicateKey(err error) bool {
+	var writeErr mongo.WriteException
+	if errors.As(err, &writeErr) {
+		for _, e := range writeErr.WriteErrors {
+			if e.Code == 11000 {
+				return true
+			}
+		}
+	}
+
+	var cmdErr mongo.CommandError
+	return errors.As(err, &cmdErr) && cmdErr.Code == 11000
+}
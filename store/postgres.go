@@ -0,0 +1,1134 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"uwo-tt-api/model"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// migrationLock is the advisory lock held while a migration is applied, so instances starting together apply it once
+const migrationLock = 7286530
+
+// Postgres stores everything in normalised PostgreSQL tables. Options and sections belong to a generation, with courses and
+// meeting times in tables of their own. The live generation is the single row of the live_generation table,
+// so every table is published at once by updating it
+type Postgres struct {
+	DB *sql.DB
+}
+
+// postgresSnapshot reads the rows of a generation
+type postgresSnapshot struct {
+	p          *Postgres
+	generation int64
+}
+
+// Snapshot takes a snapshot of the live generation
+func (p *Postgres) Snapshot(ctx context.Context) (Snapshot, error) {
+	return p.snapshot(ctx)
+}
+
+// snapshot takes a snapshot of the live generation. Generations are numbered from 1, so nothing is read before one is published
+func (p *Postgres) snapshot(ctx context.Context) (postgresSnapshot, error) {
+	s := postgresSnapshot{p: p}
+
+	err := p.DB.QueryRowContext(ctx, "SELECT generation FROM live_generation").Scan(&s.generation)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, nil
+	}
+
+	return s, err
+}
+
+// NewPostgres creates a repository backed by a database, whose schema must be brought up to date with Migrate
+func NewPostgres(db *sql.DB) *Postgres {
+	return &Postgres{DB: db}
+}
+
+// Migrate brings the schema up to date by applying every migration the database has not applied yet, in order
+func (p *Postgres) Migrate(ctx context.Context) error {
+	for i, migration := range migrations {
+		if err := p.migrate(ctx, i+1, migration); err != nil {
+			return fmt.Errorf("Failed to apply migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// migrate applies a single migration unless it was already applied
+func (p *Postgres) migrate(ctx context.Context, version int, migration string) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLock); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		applied timestamptz NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+
+	applied := false
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// columnKind is the type of the values of a column. Like in MongoDB, a column only matches values of its own type
+type columnKind int
+
+const (
+	numberColumn columnKind = iota
+	textColumn
+	timeColumn
+	// courseIDColumn is the faculty, number and suffix identifying a course, compared in that order
+	courseIDColumn
+)
+
+// column is the SQL expression of a document field
+type column struct {
+	expr string
+	kind columnKind
+	// times columns hold a value for each meeting time t of a section s, like an array of the section document
+	times bool
+}
+
+// optionColumns maps the fields of option documents to the columns of an options row o
+var optionColumns = map[string]column{
+	"_id":          {expr: "o.id", kind: numberColumn},
+	"source.title": {expr: "o.source_title", kind: textColumn},
+	"source.year":  {expr: "o.source_year", kind: textColumn},
+	"source.url":   {expr: "o.source_url", kind: textColumn},
+	"time.added":   {expr: "o.added", kind: timeColumn},
+	"data.value":   {expr: "o.value", kind: textColumn},
+	"data.text":    {expr: "o.text", kind: textColumn},
+}
+
+// sectionColumns maps the fields of section documents to the columns of a sections row s and its courses row c
+var sectionColumns = map[string]column{
+	"_id":                         {expr: "s.id", kind: numberColumn},
+	"source.title":                {expr: "s.source_title", kind: textColumn},
+	"source.year":                 {expr: "s.source_year", kind: textColumn},
+	"source.url":                  {expr: "s.source_url", kind: textColumn},
+	"time.added":                  {expr: "s.added", kind: timeColumn},
+	"courseData.faculty":          {expr: "c.faculty", kind: textColumn},
+	"courseData.number":           {expr: "c.number", kind: numberColumn},
+	"courseData.suffix":           {expr: "c.suffix", kind: textColumn},
+	"courseData.name":             {expr: "c.name", kind: textColumn},
+	"courseData.description":      {expr: "c.description", kind: textColumn},
+	"sectionData.number":          {expr: "s.number", kind: numberColumn},
+	"sectionData.component":       {expr: "s.component", kind: textColumn},
+	"sectionData.classNumber":     {expr: "s.class_number", kind: numberColumn},
+	"sectionData.location":        {expr: "s.location", kind: textColumn},
+	"sectionData.instructor":      {expr: "s.instructor", kind: textColumn},
+	"sectionData.requisites":      {expr: "s.requisites", kind: textColumn},
+	"sectionData.status":          {expr: "s.status", kind: textColumn},
+	"sectionData.campus":          {expr: "s.campus", kind: textColumn},
+	"sectionData.delivery":        {expr: "s.delivery", kind: textColumn},
+	"sectionData.times.days":      {expr: "t.days", kind: textColumn, times: true},
+	"sectionData.times.startTime": {expr: "t.start_time", kind: textColumn, times: true},
+	"sectionData.times.endTime":   {expr: "t.end_time", kind: textColumn, times: true},
+}
+
+// courseColumns maps the fields of course documents to the columns of a group g of sections, its first section f and the course fc of f
+var courseColumns = map[string]column{
+	"_id":                    {expr: "g.faculty, g.number, g.suffix", kind: courseIDColumn},
+	"source.title":           {expr: "f.source_title", kind: textColumn},
+	"source.year":            {expr: "f.source_year", kind: textColumn},
+	"source.url":             {expr: "f.source_url", kind: textColumn},
+	"time.added":             {expr: "f.added", kind: timeColumn},
+	"courseData.faculty":     {expr: "fc.faculty", kind: textColumn},
+	"courseData.number":      {expr: "fc.number", kind: numberColumn},
+	"courseData.suffix":      {expr: "fc.suffix", kind: textColumn},
+	"courseData.name":        {expr: "fc.name", kind: textColumn},
+	"courseData.description": {expr: "fc.description", kind: textColumn},
+}
+
+// sqlOps are the SQL comparison operators of each Op
+var sqlOps = map[Op]string{
+	Eq:  "=",
+	Ne:  "<>",
+	Gt:  ">",
+	Gte: ">=",
+	Lt:  "<",
+	Lte: "<=",
+}
+
+// sqlTypes are the types filter values are cast to, so they compare like values of a column of each kind
+var sqlTypes = map[columnKind]string{
+	numberColumn: "bigint",
+	textColumn:   "text",
+	timeColumn:   "timestamptz",
+}
+
+// sectionData builds the JSON of the sectionData of a sections row
+func sectionData(alias string) string {
+	return fmt.Sprintf(`json_build_object(
+		'number', %[1]s.number, 'component', %[1]s.component, 'classNumber', %[1]s.class_number,
+		'location', %[1]s.location, 'instructor', %[1]s.instructor, 'requisites', %[1]s.requisites,
+		'status', %[1]s.status, 'campus', %[1]s.campus, 'delivery', %[1]s.delivery,
+		'times', (SELECT json_agg(json_build_object('days', t.days, 'startTime', t.start_time, 'endTime', t.end_time) ORDER BY t.position)
+			FROM meeting_times t WHERE t.section_id = %[1]s.id))`, alias)
+}
+
+// sqlQuery builds a SQL statement along with its arguments
+type sqlQuery struct {
+	args []interface{}
+}
+
+// arg adds an argument cast to the type of a column kind and returns its placeholder
+func (b *sqlQuery) arg(v interface{}, kind columnKind) string {
+	b.args = append(b.args, v)
+
+	// Fractions compare exactly with integer columns
+	if _, ok := v.(float64); ok {
+		return fmt.Sprintf("$%d::numeric", len(b.args))
+	}
+
+	return fmt.Sprintf("$%d::%s", len(b.args), sqlTypes[kind])
+}
+
+// generation builds the condition that a row of a table belongs to a generation
+func (b *sqlQuery) generation(alias string, number int64) string {
+	return alias + ".generation = " + b.arg(number, numberColumn)
+}
+
+// where builds the condition of a filter on the given columns
+func (b *sqlQuery) where(f Filter, columns map[string]column) string {
+	clauses := []string{}
+
+	for _, c := range f.Conditions {
+		clauses = append(clauses, b.condition(c, columns))
+	}
+
+	for _, nested := range f.Filters {
+		clauses = append(clauses, "("+b.where(nested, columns)+")")
+	}
+
+	if len(clauses) == 0 {
+		return "TRUE"
+	}
+
+	if f.Any {
+		return strings.Join(clauses, " OR ")
+	}
+
+	return strings.Join(clauses, " AND ")
+}
+
+// condition builds a single condition. Like in MongoDB, a missing field or a value of another type only matches Ne
+func (b *sqlQuery) condition(c Condition, columns map[string]column) string {
+	op, ok := sqlOps[c.Op]
+	col, known := columns[c.Field]
+
+	var values []interface{}
+	if ok && known {
+		values, ok = columnValues(col.kind, c.Value)
+	}
+
+	if !ok || !known {
+		if c.Op == Ne {
+			return "TRUE"
+		}
+
+		return "FALSE"
+	}
+
+	switch {
+	case col.kind == courseIDColumn:
+		params := fmt.Sprintf("%s, %s, %s", b.arg(values[0], textColumn), b.arg(values[1], numberColumn), b.arg(values[2], textColumn))
+		return fmt.Sprintf("(%s) %s (%s)", col.expr, op, params)
+	case col.times && c.Op == Ne:
+		// No meeting time may be equal
+		return fmt.Sprintf("NOT EXISTS (SELECT 1 FROM meeting_times t WHERE t.section_id = s.id AND %s = %s)", col.expr, b.arg(values[0], col.kind))
+	case col.times:
+		return fmt.Sprintf("EXISTS (SELECT 1 FROM meeting_times t WHERE t.section_id = s.id AND %s %s %s)", col.expr, op, b.arg(values[0], col.kind))
+	default:
+		return fmt.Sprintf("%s %s %s", col.expr, op, b.arg(values[0], col.kind))
+	}
+}
+
+// columnValues converts a filter value into the arguments compared with a column, if it has the type of the column
+func columnValues(kind columnKind, value interface{}) ([]interface{}, bool) {
+	switch kind {
+	case numberColumn:
+		switch v := value.(type) {
+		case int:
+			return []interface{}{int64(v)}, true
+		case int32:
+			return []interface{}{int64(v)}, true
+		case int64:
+			return []interface{}{v}, true
+		case float64:
+			return []interface{}{v}, true
+		}
+	case textColumn:
+		if v, ok := value.(string); ok {
+			return []interface{}{v}, true
+		}
+	case timeColumn:
+		switch v := value.(type) {
+		case time.Time:
+			return []interface{}{v}, true
+		case primitive.DateTime:
+			return []interface{}{time.Unix(0, int64(v)*int64(time.Millisecond))}, true
+		}
+	case courseIDColumn:
+		id, ok := value.(bson.D)
+		if !ok || len(id) != 3 || id[0].Key != "faculty" || id[1].Key != "number" || id[2].Key != "suffix" {
+			return nil, false
+		}
+
+		faculty, ok := columnValues(textColumn, id[0].Value)
+		number, numberOK := columnValues(numberColumn, id[1].Value)
+		suffix, suffixOK := columnValues(textColumn, id[2].Value)
+		if !ok || !numberOK || !suffixOK {
+			return nil, false
+		}
+
+		return []interface{}{faculty[0], number[0], suffix[0]}, true
+	}
+
+	return nil, false
+}
+
+// sortExprs returns the expressions a column sorts by. Like in MongoDB, meeting time columns sort by their lowest value,
+// or their highest when sorting descending
+func sortExprs(col column, highest bool) []string {
+	if col.kind == courseIDColumn {
+		return strings.Split(col.expr, ", ")
+	}
+
+	if !col.times {
+		return []string{col.expr}
+	}
+
+	accumulator := "min"
+	if highest {
+		accumulator = "max"
+	}
+
+	return []string{fmt.Sprintf("(SELECT %s(%s) FROM meeting_times t WHERE t.section_id = s.id)", accumulator, col.expr)}
+}
+
+// orderBy builds the ORDER BY, OFFSET and LIMIT clauses of a query. Missing values sort first, like in MongoDB
+func orderBy(q Query, columns map[string]column) string {
+	dir := " ASC NULLS FIRST"
+	if q.Desc {
+		dir = " DESC NULLS LAST"
+	}
+
+	terms := []string{}
+	for _, key := range q.Sort {
+		// A missing field is equal in every document
+		if col, ok := columns[key]; ok {
+			for _, expr := range sortExprs(col, q.Desc) {
+				terms = append(terms, expr+dir)
+			}
+		}
+	}
+
+	result := ""
+	if len(terms) > 0 {
+		result += " ORDER BY " + strings.Join(terms, ", ")
+	}
+
+	if q.Skip != 0 {
+		result += fmt.Sprintf(" OFFSET %d", q.Skip)
+	}
+
+	if q.Limit != 0 {
+		result += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	return result
+}
+
+// keyExprs returns the expressions selecting the values of sort keys. Meeting times and missing fields have no value
+func keyExprs(keys []string, columns map[string]column) []string {
+	exprs := []string{}
+	for _, key := range keys {
+		col, ok := columns[key]
+
+		switch {
+		case !ok || col.times:
+			exprs = append(exprs, "NULL")
+		case col.kind == courseIDColumn:
+			exprs = append(exprs, sortExprs(col, false)...)
+		default:
+			exprs = append(exprs, col.expr)
+		}
+	}
+
+	return exprs
+}
+
+// readKeyRows reads the sort keys selected by keyExprs from every row and closes the rows
+func readKeyRows(rows *sql.Rows, keys []string, columns map[string]column) ([][]interface{}, error) {
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := [][]interface{}{}
+	for rows.Next() {
+		scanned := make([]interface{}, len(names))
+		dest := make([]interface{}, len(names))
+		for i := range dest {
+			dest[i] = &scanned[i]
+		}
+
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		for i, value := range scanned {
+			switch v := value.(type) {
+			case []byte:
+				scanned[i] = string(v)
+			case time.Time:
+				scanned[i] = v.UTC()
+			}
+		}
+
+		values := make([]interface{}, len(keys))
+		for i, key := range keys {
+			if col := columns[key]; col.kind == courseIDColumn {
+				values[i] = bson.D{
+					{Key: "faculty", Value: scanned[0]},
+					{Key: "number", Value: scanned[1]},
+					{Key: "suffix", Value: scanned[2]},
+				}
+
+				scanned = scanned[3:]
+				continue
+			}
+
+			values[i] = scanned[0]
+			scanned = scanned[1:]
+		}
+
+		result = append(result, values)
+	}
+
+	return result, rows.Err()
+}
+
+// rowCursor decodes each row read by a query into a document as it is iterated
+type rowCursor struct {
+	rows *sql.Rows
+	scan func(rows *sql.Rows) (document, error)
+	doc  document
+	err  error
+}
+
+func (c *rowCursor) Next(ctx context.Context) bool {
+	if c.err != nil || !c.rows.Next() {
+		return false
+	}
+
+	c.doc, c.err = c.scan(c.rows)
+	return c.err == nil
+}
+
+func (c *rowCursor) Decode(v interface{}) error {
+	return bson.Unmarshal(c.doc.raw, v)
+}
+
+func (c *rowCursor) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+
+	return c.rows.Err()
+}
+
+func (c *rowCursor) Close(ctx context.Context) error {
+	return c.rows.Close()
+}
+
+// CountOptions counts the live options of a collection matching a filter
+func (p *Postgres) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountOptions(ctx, name, filter)
+}
+
+// CountOptions counts the options of a collection matching a filter
+func (s postgresSnapshot) CountOptions(ctx context.Context, name string, filter Filter) (int64, error) {
+	b := &sqlQuery{}
+	query := fmt.Sprintf("SELECT count(*) FROM options o WHERE %s AND o.collection = %s AND (%s)",
+		b.generation("o", s.generation), b.arg(name, textColumn), b.where(filter, optionColumns))
+
+	count := int64(0)
+	err := s.p.DB.QueryRowContext(ctx, query, b.args...).Scan(&count)
+
+	return count, err
+}
+
+// optionRows runs a query of the options of a collection
+func (s postgresSnapshot) optionRows(ctx context.Context, exprs []string, name string, q Query) (*sql.Rows, error) {
+	b := &sqlQuery{}
+	query := fmt.Sprintf("SELECT %s FROM options o WHERE %s AND o.collection = %s AND (%s)%s",
+		strings.Join(exprs, ", "),
+		b.generation("o", s.generation),
+		b.arg(name, textColumn),
+		b.where(q.Filter, optionColumns),
+		orderBy(q, optionColumns))
+
+	return s.p.DB.QueryContext(ctx, query, b.args...)
+}
+
+// FindOptions finds the live options of a collection selected by a query
+func (p *Postgres) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindOptions(ctx, name, q)
+}
+
+// FindOptions finds the options of a collection selected by a query. Every field is returned as fields are only a db optimisation
+func (s postgresSnapshot) FindOptions(ctx context.Context, name string, q Query) (Cursor, error) {
+	exprs := []string{"o.id", "o.source_title", "o.source_year", "o.source_url", "o.added", "o.value", "o.text"}
+
+	rows, err := s.optionRows(ctx, exprs, name, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowCursor{rows: rows, scan: scanOption}, nil
+}
+
+// scanOption reads an option selected by FindOptions
+func scanOption(rows *sql.Rows) (document, error) {
+	var id int64
+	var opt model.Option
+
+	err := rows.Scan(&id, &opt.Source.Title, &opt.Source.Year, &opt.Source.URL, &opt.Time.Added, &opt.Data.Value, &opt.Data.Text)
+	if err != nil {
+		return document{}, err
+	}
+
+	return newDocument(id, opt)
+}
+
+// OptionKeys finds the values of the sort keys of the live options selected by a query
+func (p *Postgres) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.OptionKeys(ctx, name, q)
+}
+
+// OptionKeys finds the values of the sort keys of the options selected by a query
+func (s postgresSnapshot) OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error) {
+	rows, err := s.optionRows(ctx, keyExprs(q.Sort, optionColumns), name, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeyRows(rows, q.Sort, optionColumns)
+}
+
+// WriteOptions replaces every option of a collection in a generation in a single transaction
+func (p *Postgres) WriteOptions(ctx context.Context, generation int64, name string, opts []model.Option) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM options WHERE generation = $1 AND collection = $2", generation, name); err != nil {
+		return err
+	}
+
+	insert, err := tx.PrepareContext(ctx, `INSERT INTO options (generation, collection, source_title, source_year, source_url, added, value, text)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+	if err != nil {
+		return err
+	}
+
+	defer insert.Close()
+
+	for _, opt := range opts {
+		// Times are kept to the millisecond like in MongoDB
+		added := opt.Time.Added.Truncate(time.Millisecond)
+
+		_, err := insert.ExecContext(ctx, generation, name, opt.Source.Title, opt.Source.Year, opt.Source.URL, added,
+			opt.Data.Value, opt.Data.Text)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// CountSections counts the live sections matching a filter
+func (p *Postgres) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountSections(ctx, filter)
+}
+
+// CountSections counts the sections matching a filter
+func (s postgresSnapshot) CountSections(ctx context.Context, filter Filter) (int64, error) {
+	b := &sqlQuery{}
+	query := fmt.Sprintf("SELECT count(*) FROM sections s JOIN courses c ON c.id = s.course_id WHERE %s AND (%s)",
+		b.generation("s", s.generation), b.where(filter, sectionColumns))
+
+	count := int64(0)
+	err := s.p.DB.QueryRowContext(ctx, query, b.args...).Scan(&count)
+
+	return count, err
+}
+
+// sectionRows runs a query of the sections
+func (s postgresSnapshot) sectionRows(ctx context.Context, exprs []string, q Query) (*sql.Rows, error) {
+	b := &sqlQuery{}
+	query := fmt.Sprintf("SELECT %s FROM sections s JOIN courses c ON c.id = s.course_id WHERE %s AND (%s)%s",
+		strings.Join(exprs, ", "),
+		b.generation("s", s.generation),
+		b.where(q.Filter, sectionColumns),
+		orderBy(q, sectionColumns))
+
+	return s.p.DB.QueryContext(ctx, query, b.args...)
+}
+
+// FindSections finds the live sections selected by a query
+func (p *Postgres) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindSections(ctx, q)
+}
+
+// FindSections finds the sections selected by a query. Every field is returned as fields are only a db optimisation
+func (s postgresSnapshot) FindSections(ctx context.Context, q Query) (Cursor, error) {
+	exprs := []string{
+		"s.id", "s.key", "s.source_title", "s.source_year", "s.source_url", "s.added",
+		"c.faculty", "c.number", "c.suffix", "c.name", "c.description",
+		sectionData("s"),
+	}
+
+	rows, err := s.sectionRows(ctx, exprs, q)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowCursor{rows: rows, scan: scanSection}, nil
+}
+
+// scanSection reads a section selected by FindSections
+func scanSection(rows *sql.Rows) (document, error) {
+	var id int64
+	var key sql.NullString
+	var section model.Section
+	var data []byte
+
+	err := rows.Scan(&id, &key, &section.Source.Title, &section.Source.Year, &section.Source.URL, &section.Time.Added,
+		&section.CourseData.Faculty, &section.CourseData.Number, &section.CourseData.Suffix,
+		&section.CourseData.Name, &section.CourseData.Description, &data)
+	if err != nil {
+		return document{}, err
+	}
+
+	if err := json.Unmarshal(data, &section.SectionData); err != nil {
+		return document{}, err
+	}
+
+	section.ID = key.String
+
+	return newDocument(id, section)
+}
+
+// SectionKeys finds the values of the sort keys of the live sections selected by a query
+func (p *Postgres) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.SectionKeys(ctx, q)
+}
+
+// SectionKeys finds the values of the sort keys of the sections selected by a query
+func (s postgresSnapshot) SectionKeys(ctx context.Context, q Query) ([][]interface{}, error) {
+	rows, err := s.sectionRows(ctx, keyExprs(q.Sort, sectionColumns), q)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeyRows(rows, q.Sort, sectionColumns)
+}
+
+// WriteSections writes sections into a generation in a single transaction. A section with an id updates the row
+// of the section with the same key in place, replacing its meeting times
+func (p *Postgres) WriteSections(ctx context.Context, generation int64, sections []model.Section) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, section := range sections {
+		// Sections of the same course share its row
+		course := section.CourseData
+		courseID := int64(0)
+		err = tx.QueryRowContext(ctx, `INSERT INTO courses (faculty, number, suffix, name, description) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (faculty, number, suffix, name, md5(description)) DO UPDATE SET faculty = EXCLUDED.faculty
+			RETURNING id`,
+			course.Faculty, course.Number, course.Suffix, course.Name, course.Description).Scan(&courseID)
+		if err != nil {
+			return err
+		}
+
+		// Sections without an id have no key, which never conflicts
+		key := sql.NullString{String: section.ID, Valid: section.ID != ""}
+		data := section.SectionData
+
+		id := int64(0)
+		err := tx.QueryRowContext(ctx, `INSERT INTO sections (generation, key, course_id, source_title, source_year, source_url, added,
+			number, component, class_number, location, instructor, requisites, status, campus, delivery)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)
+			ON CONFLICT (generation, key) DO UPDATE SET course_id = EXCLUDED.course_id, source_title = EXCLUDED.source_title,
+			source_year = EXCLUDED.source_year, source_url = EXCLUDED.source_url, added = EXCLUDED.added, number = EXCLUDED.number,
+			component = EXCLUDED.component, class_number = EXCLUDED.class_number, location = EXCLUDED.location,
+			instructor = EXCLUDED.instructor, requisites = EXCLUDED.requisites, status = EXCLUDED.status, campus = EXCLUDED.campus,
+			delivery = EXCLUDED.delivery
+			RETURNING id`,
+			generation, key, courseID, section.Source.Title, section.Source.Year, section.Source.URL, section.Time.Added.Truncate(time.Millisecond),
+			data.Number, data.Component, data.ClassNumber, data.Location, data.Instructor, data.Reqs, data.Status, data.Campus, data.Delivery).Scan(&id)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM meeting_times WHERE section_id = $1", id); err != nil {
+			return err
+		}
+
+		for i, t := range data.Times {
+			_, err := tx.ExecContext(ctx, `INSERT INTO meeting_times (section_id, position, days, start_time, end_time)
+				VALUES ($1, $2, $3, $4, $5)`,
+				id, i, t.Day, t.StartTime, t.EndTime)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// NewGeneration adds a generation numbered by the sequence of the generations table
+func (p *Postgres) NewGeneration(ctx context.Context, run string) (model.Generation, error) {
+	gen := model.Generation{Run: run, Created: time.Now().UTC().Truncate(time.Microsecond)}
+
+	err := p.DB.QueryRowContext(ctx, "INSERT INTO generations (run, created) VALUES ($1, $2) RETURNING number",
+		gen.Run, gen.Created).Scan(&gen.Number)
+
+	return gen, err
+}
+
+// Generations lists the generations in the generations table, newest first
+func (p *Postgres) Generations(ctx context.Context) ([]model.Generation, error) {
+	rows, err := p.DB.QueryContext(ctx, `SELECT g.number, g.run, g.created, g.published, l.generation IS NOT NULL
+		FROM generations g LEFT JOIN live_generation l ON l.generation = g.number
+		ORDER BY g.number DESC`)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	gens := []model.Generation{}
+	for rows.Next() {
+		var gen model.Generation
+		var published sql.NullTime
+
+		if err := rows.Scan(&gen.Number, &gen.Run, &gen.Created, &published, &gen.Live); err != nil {
+			return nil, err
+		}
+
+		gen.Created = gen.Created.UTC()
+		if published.Valid {
+			gen.Published = published.Time.UTC()
+		}
+
+		gens = append(gens, gen)
+	}
+
+	return gens, rows.Err()
+}
+
+// PublishGeneration points the row of the live_generation table at a generation
+func (p *Postgres) PublishGeneration(ctx context.Context, number int64) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "UPDATE generations SET published = coalesce(published, now()) WHERE number = $1", number)
+	if err != nil {
+		return err
+	}
+
+	if updated, err := res.RowsAffected(); err != nil {
+		return err
+	} else if updated == 0 {
+		return fmt.Errorf("Generation %d does not exist", number)
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO live_generation (generation) VALUES ($1)
+		ON CONFLICT (id) DO UPDATE SET generation = EXCLUDED.generation`, number)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DeleteGeneration deletes a generation that is not live, along with its options and sections, then the courses
+// left without sections
+func (p *Postgres) DeleteGeneration(ctx context.Context, number int64) error {
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	// The live generation must not change until the generation is deleted
+	live := int64(0)
+	err = tx.QueryRowContext(ctx, "SELECT generation FROM live_generation FOR UPDATE").Scan(&live)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if number == live {
+		return fmt.Errorf("Generation %d is live", number)
+	}
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM generations WHERE number = $1", number)
+	if err != nil {
+		return err
+	}
+
+	if deleted, err := res.RowsAffected(); err != nil {
+		return err
+	} else if deleted == 0 {
+		return fmt.Errorf("Generation %d does not exist", number)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM courses c WHERE NOT EXISTS (SELECT 1 FROM sections s WHERE s.course_id = c.id)")
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// courseSQL builds the FROM clause and the rest of a query of the courses grouped from the sections of a generation matching a query.
+// The sections of each course are grouped in g along with the aggregated values of section sort keys, f is the first section
+// of the course and fc its course row. The query of the page is returned with the columns of its fields
+func courseSQL(b *sqlQuery, generation int64, q CourseQuery) (string, Query, map[string]column) {
+	accumulator := "min"
+	if q.Highest {
+		accumulator = "max"
+	}
+
+	columns := map[string]column{}
+	for key, col := range courseColumns {
+		columns[key] = col
+	}
+
+	keys := ""
+	for key, name := range q.sectionKeys() {
+		// A missing field has no value in any course
+		col, ok := sectionColumns[key]
+		if !ok {
+			continue
+		}
+
+		alias := strings.ToLower(name)
+		keys += fmt.Sprintf(", %s(%s) AS %s", accumulator, sortExprs(col, q.Highest)[0], alias)
+		columns[name] = column{expr: "g." + alias, kind: col.kind}
+	}
+
+	page := q.courseQuery()
+
+	groups := fmt.Sprintf(`SELECT c.faculty, c.number, c.suffix, min(s.id) AS first_id, json_agg(%s ORDER BY s.id) AS sections%s
+		FROM sections s JOIN courses c ON c.id = s.course_id
+		WHERE %s AND (%s)
+		GROUP BY c.faculty, c.number, c.suffix`,
+		sectionData("s"), keys, b.generation("s", generation), b.where(q.Sections, sectionColumns))
+
+	from := fmt.Sprintf(` FROM (%s) g
+		JOIN sections f ON f.id = g.first_id
+		JOIN courses fc ON fc.id = f.course_id
+		WHERE %s%s`,
+		groups, b.where(page.Filter, columns), orderBy(page, columns))
+
+	return from, page, columns
+}
+
+// CountCourses counts the live courses with a section matching a filter
+func (p *Postgres) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.CountCourses(ctx, filter)
+}
+
+// CountCourses counts the courses with a section matching a filter
+func (s postgresSnapshot) CountCourses(ctx context.Context, filter Filter) (int64, error) {
+	b := &sqlQuery{}
+	query := fmt.Sprintf(`SELECT count(*) FROM (
+		SELECT 1 FROM sections s JOIN courses c ON c.id = s.course_id
+		WHERE %s AND (%s)
+		GROUP BY c.faculty, c.number, c.suffix) g`, b.generation("s", s.generation), b.where(filter, sectionColumns))
+
+	count := int64(0)
+	err := s.p.DB.QueryRowContext(ctx, query, b.args...).Scan(&count)
+
+	return count, err
+}
+
+// FindCourses finds the live courses selected by a query
+func (p *Postgres) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.FindCourses(ctx, q)
+}
+
+// FindCourses finds the courses selected by a query
+func (s postgresSnapshot) FindCourses(ctx context.Context, q CourseQuery) (Cursor, error) {
+	sections := "g.sections"
+	if q.AllSections {
+		sections = fmt.Sprintf("(SELECT json_agg(%s ORDER BY a.id) FROM sections a WHERE a.generation = f.generation AND a.course_id = f.course_id)", sectionData("a"))
+	}
+
+	exprs := []string{
+		"g.faculty", "g.number", "g.suffix", "f.source_title", "f.source_year", "f.source_url", "f.added",
+		"fc.faculty", "fc.number", "fc.suffix", "fc.name", "fc.description", sections,
+	}
+
+	b := &sqlQuery{}
+	from, _, _ := courseSQL(b, s.generation, q)
+
+	rows, err := s.p.DB.QueryContext(ctx, "SELECT "+strings.Join(exprs, ", ")+from, b.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rowCursor{rows: rows, scan: scanCourse}, nil
+}
+
+// scanCourse reads a course selected by FindCourses
+func scanCourse(rows *sql.Rows) (document, error) {
+	var faculty, suffix string
+	var number int
+	var course model.Course
+	var data []byte
+
+	err := rows.Scan(&faculty, &number, &suffix, &course.Source.Title, &course.Source.Year, &course.Source.URL, &course.Time.Added,
+		&course.CourseData.Faculty, &course.CourseData.Number, &course.CourseData.Suffix,
+		&course.CourseData.Name, &course.CourseData.Description, &data)
+	if err != nil {
+		return document{}, err
+	}
+
+	if err := json.Unmarshal(data, &course.SectionData); err != nil {
+		return document{}, err
+	}
+
+	id := bson.D{
+		{Key: "faculty", Value: faculty},
+		{Key: "number", Value: number},
+		{Key: "suffix", Value: suffix},
+	}
+
+	return newDocument(id, course)
+}
+
+// CourseKeys finds the values of the sort keys of the live courses selected by a query
+func (p *Postgres) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.CourseKeys(ctx, q)
+}
+
+// CourseKeys finds the values of the sort keys of the courses selected by a query
+func (s postgresSnapshot) CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error) {
+	b := &sqlQuery{}
+	from, page, columns := courseSQL(b, s.generation, q)
+
+	rows, err := s.p.DB.QueryContext(ctx, "SELECT "+strings.Join(keyExprs(page.Sort, columns), ", ")+from, b.args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return readKeyRows(rows, page.Sort, columns)
+}
+
+// SaveRun creates or updates a run
+func (p *Postgres) SaveRun(ctx context.Context, run model.Run) error {
+	problems, err := json.Marshal(run.Problems)
+	if err != nil {
+		return err
+	}
+
+	checkpoints, err := json.Marshal(run.Checkpoints)
+	if err != nil {
+		return err
+	}
+
+	targets, err := json.Marshal(run.Targets)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.DB.ExecContext(ctx, `INSERT INTO runs (id, started, finished, status, subjects, sections, generation, problems, checkpoints, targets)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (id) DO UPDATE SET started = EXCLUDED.started, finished = EXCLUDED.finished, status = EXCLUDED.status,
+		subjects = EXCLUDED.subjects, sections = EXCLUDED.sections, generation = EXCLUDED.generation, problems = EXCLUDED.problems,
+		checkpoints = EXCLUDED.checkpoints, targets = EXCLUDED.targets`,
+		run.ID, run.Started, run.Finished, run.Status, run.Subjects, run.Sections, run.Generation, string(problems), string(checkpoints),
+		string(targets))
+
+	return err
+}
+
+// Ping checks that the database can be reached
+func (p *Postgres) Ping(ctx context.Context) error {
+	return p.DB.PingContext(ctx)
+}
+
+// LatestRun finds the run that wrote the live generation
+func (p *Postgres) LatestRun(ctx context.Context) (model.Run, error) {
+	s, err := p.snapshot(ctx)
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	return s.Run(ctx)
+}
+
+// Run finds the run that wrote the generation
+func (s postgresSnapshot) Run(ctx context.Context) (model.Run, error) {
+	return scanRun(s.p.DB.QueryRowContext(ctx, `SELECT `+runColumns+`
+		FROM runs r JOIN generations g ON g.run = r.id WHERE g.number = $1`, s.generation))
+}
+
+// UnfinishedRun finds the most recently started run of a kind that is still running. Targets without subjects are
+// stored as null or an empty array
+func (p *Postgres) UnfinishedRun(ctx context.Context, targeted bool) (model.Run, error) {
+	return scanRun(p.DB.QueryRowContext(ctx, `SELECT `+runColumns+` FROM runs r
+		WHERE r.status = $1 AND (jsonb_typeof(r.targets) = 'array' AND jsonb_array_length(r.targets) > 0) = $2
+		ORDER BY r.started DESC LIMIT 1`,
+		model.RunRunning, targeted))
+}
+
+// runColumns are the columns of a run read by scanRun
+const runColumns = "r.id, r.started, r.finished, r.status, r.subjects, r.sections, r.generation, r.problems, r.checkpoints, r.targets"
+
+// scanRun reads a run selected with runColumns
+func scanRun(row *sql.Row) (model.Run, error) {
+	var run model.Run
+	var problems, checkpoints, targets []byte
+
+	err := row.Scan(&run.ID, &run.Started, &run.Finished, &run.Status, &run.Subjects, &run.Sections, &run.Generation, &problems, &checkpoints,
+		&targets)
+	if errors.Is(err, sql.ErrNoRows) {
+		return run, ErrNotFound
+	} else if err != nil {
+		return run, err
+	}
+
+	run.Started, run.Finished = run.Started.UTC(), run.Finished.UTC()
+
+	if err := json.Unmarshal(problems, &run.Problems); err != nil {
+		return run, err
+	}
+
+	if err := json.Unmarshal(targets, &run.Targets); err != nil {
+		return run, err
+	}
+
+	return run, json.Unmarshal(checkpoints, &run.Checkpoints)
+}
+
+// AcquireLease takes or renews the named lease for a holder. The lease row is only updated if the holder has it or it
+// expired, by the clock of the database so every instance agrees on it
+func (p *Postgres) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	res, err := p.DB.ExecContext(ctx, `INSERT INTO leases (name, holder, expires) VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+		ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires = EXCLUDED.expires
+		WHERE leases.holder = EXCLUDED.holder OR leases.expires <= now()`, name, holder, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+
+	acquired, err := res.RowsAffected()
+
+	return acquired == 1, err
+}
+
+// ReleaseLease gives up the named lease if the holder has it
+func (p *Postgres) ReleaseLease(ctx context.Context, name string, holder string) error {
+	_, err := p.DB.ExecContext(ctx, "DELETE FROM leases WHERE name = $1 AND holder = $2", name, holder)
+
+	return err
+}
@@ -0,0 +1,328 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"uwo-tt-api/model"
+
+	_ "github.com/lib/pq" // Postgres driver for database/sql
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPostgresConditions(t *testing.T) {
+	added := time.Date(2020, 9, 1, 12, 0, 0, 0, time.UTC)
+	courseID := bson.D{{Key: "faculty", Value: "CS"}, {Key: "number", Value: 1026}, {Key: "suffix", Value: ""}}
+
+	tests := []struct {
+		name    string
+		c       Condition
+		columns map[string]column
+		want    string
+		args    []interface{}
+	}{
+		{"number", Condition{"courseData.number", Eq, 1026}, sectionColumns, "c.number = $1::bigint", []interface{}{int64(1026)}},
+		{"fraction", Condition{"courseData.number", Lt, 1600.5}, sectionColumns, "c.number < $1::numeric", []interface{}{1600.5}},
+		{"text", Condition{"courseData.faculty", Ne, "CS"}, sectionColumns, "c.faculty <> $1::text", []interface{}{"CS"}},
+		{"time", Condition{"time.added", Gte, primitive.NewDateTimeFromTime(added)}, sectionColumns, "s.added >= $1::timestamptz", []interface{}{added.Local()}},
+		{"other type", Condition{"courseData.number", Gt, "1000"}, sectionColumns, "FALSE", nil},
+		{"other type ne", Condition{"courseData.number", Ne, "1000"}, sectionColumns, "TRUE", nil},
+		{"missing field", Condition{"sectionData.missing", Eq, 1}, sectionColumns, "FALSE", nil},
+		{"missing field ne", Condition{"sectionData.missing", Ne, 1}, sectionColumns, "TRUE", nil},
+		{
+			"meeting time", Condition{"sectionData.times.days", Eq, "M"}, sectionColumns,
+			"EXISTS (SELECT 1 FROM meeting_times t WHERE t.section_id = s.id AND t.days = $1::text)", []interface{}{"M"},
+		},
+		{
+			"meeting time ne", Condition{"sectionData.times.days", Ne, "M"}, sectionColumns,
+			"NOT EXISTS (SELECT 1 FROM meeting_times t WHERE t.section_id = s.id AND t.days = $1::text)", []interface{}{"M"},
+		},
+		{
+			"course id", Condition{"_id", Gt, courseID}, courseColumns,
+			"(g.faculty, g.number, g.suffix) > ($1::text, $2::bigint, $3::text)", []interface{}{"CS", int64(1026), ""},
+		},
+		{"course id of other fields", Condition{"_id", Gt, bson.D{{Key: "faculty", Value: "CS"}}}, courseColumns, "FALSE", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &sqlQuery{}
+
+			if got := b.condition(test.c, test.columns); got != test.want {
+				t.Errorf("condition is %q, want %q", got, test.want)
+			}
+
+			if !reflect.DeepEqual(b.args, test.args) {
+				t.Errorf("arguments are %#v, want %#v", b.args, test.args)
+			}
+		})
+	}
+}
+
+func TestPostgresWhere(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+		want   string
+		args   []interface{}
+	}{
+		{"empty", Filter{}, "TRUE", nil},
+		{
+			"all", Filter{Conditions: []Condition{{"courseData.faculty", Eq, "CS"}, {"sectionData.number", Gte, 2}}},
+			"c.faculty = $1::text AND s.number >= $2::bigint", []interface{}{"CS", int64(2)},
+		},
+		{
+			"any nested", Filter{Any: true, Conditions: []Condition{{"courseData.faculty", Eq, "CS"}}, Filters: []Filter{
+				{Conditions: []Condition{{"sectionData.number", Eq, 2}, {"courseData.number", Eq, 1026}}},
+			}},
+			"c.faculty = $1::text OR (s.number = $2::bigint AND c.number = $3::bigint)", []interface{}{"CS", int64(2), int64(1026)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b := &sqlQuery{}
+
+			if got := b.where(test.filter, sectionColumns); got != test.want {
+				t.Errorf("where is %q, want %q", got, test.want)
+			}
+
+			if !reflect.DeepEqual(b.args, test.args) {
+				t.Errorf("arguments are %#v, want %#v", b.args, test.args)
+			}
+		})
+	}
+}
+
+func TestPostgresOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		q       Query
+		columns map[string]column
+		want    string
+	}{
+		{"unsorted", Query{}, sectionColumns, ""},
+		{"ascending", Query{Sort: []string{"courseData.number", "_id"}}, sectionColumns, " ORDER BY c.number ASC NULLS FIRST, s.id ASC NULLS FIRST"},
+		{
+			"descending page", Query{Sort: []string{"courseData.number"}, Desc: true, Skip: 10, Limit: 5}, sectionColumns,
+			" ORDER BY c.number DESC NULLS LAST OFFSET 10 LIMIT 5",
+		},
+		{"missing field", Query{Sort: []string{"sectionData.missing", "_id"}}, sectionColumns, " ORDER BY s.id ASC NULLS FIRST"},
+		{
+			"lowest meeting time", Query{Sort: []string{"sectionData.times.startTime"}}, sectionColumns,
+			" ORDER BY (SELECT min(t.start_time) FROM meeting_times t WHERE t.section_id = s.id) ASC NULLS FIRST",
+		},
+		{
+			"highest meeting time", Query{Sort: []string{"sectionData.times.startTime"}, Desc: true}, sectionColumns,
+			" ORDER BY (SELECT max(t.start_time) FROM meeting_times t WHERE t.section_id = s.id) DESC NULLS LAST",
+		},
+		{"course id", Query{Sort: []string{"_id"}}, courseColumns, " ORDER BY g.faculty ASC NULLS FIRST, g.number ASC NULLS FIRST, g.suffix ASC NULLS FIRST"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := orderBy(test.q, test.columns); got != test.want {
+				t.Errorf("order is %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPostgresKeyExprs(t *testing.T) {
+	got := keyExprs([]string{"sectionData.times.days", "courseData.number", "sectionData.missing", "_id"}, sectionColumns)
+	want := []string{"NULL", "c.number", "NULL", "s.id"}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("key expressions are %q, want %q", got, want)
+	}
+}
+
+func TestPostgresCourseSQL(t *testing.T) {
+	q := CourseQuery{
+		Query: Query{
+			Filter: Filter{Conditions: []Condition{{"sectionData.number", Gt, 1}}},
+			Sort:   []string{"sectionData.number", "_id"},
+			Desc:   true,
+			Limit:  3,
+		},
+		Sections: Filter{Conditions: []Condition{{"courseData.faculty", Eq, "CS"}}},
+		Highest:  true,
+	}
+
+	b := &sqlQuery{}
+	from, page, columns := courseSQL(b, 3, q)
+
+	// Section sort keys are aggregated into the groups under their course field
+	for _, want := range []string{
+		"max(s.number) AS sortkey0",
+		"WHERE s.generation = $1::bigint AND (c.faculty = $2::text)",
+		"WHERE g.sortkey0 > $3::bigint",
+		" ORDER BY g.sortkey0 DESC NULLS LAST, g.faculty DESC NULLS LAST, g.number DESC NULLS LAST, g.suffix DESC NULLS LAST LIMIT 3",
+	} {
+		if !strings.Contains(from, want) {
+			t.Errorf("query %s does not contain %q", from, want)
+		}
+	}
+
+	if want := []interface{}{int64(3), "CS", int64(1)}; !reflect.DeepEqual(b.args, want) {
+		t.Errorf("arguments are %#v, want %#v", b.args, want)
+	}
+
+	if want := []string{"sortKey0", "_id"}; !reflect.DeepEqual(page.Sort, want) {
+		t.Errorf("sort is %q, want %q", page.Sort, want)
+	}
+
+	if col := columns["sortKey0"]; col.expr != "g.sortkey0" || col.kind != numberColumn {
+		t.Errorf("sort key column is %+v", col)
+	}
+
+	// Lowest values are used otherwise
+	if from, _, _ := courseSQL(&sqlQuery{}, 3, CourseQuery{Query: Query{Sort: []string{"sectionData.times.days"}}}); !strings.Contains(from,
+		"min((SELECT min(t.days) FROM meeting_times t WHERE t.section_id = s.id)) AS sortkey0") {
+		t.Errorf("query %s does not sort by the lowest meeting time", from)
+	}
+}
+
+// TestPostgres runs the queries of the memory tests against the database at TEST_POSTGRES. Its public schema is dropped first,
+// so it must be a database only used for testing
+func TestPostgres(t *testing.T) {
+	url := os.Getenv("TEST_POSTGRES")
+	if url == "" {
+		t.Skip("TEST_POSTGRES is not set")
+	}
+
+	ctx := context.Background()
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.ExecContext(ctx, "DROP SCHEMA public CASCADE; CREATE SCHEMA public"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPostgres(db)
+
+	// Migrating an up to date schema does nothing
+	for i := 0; i < 2; i++ {
+		if err := p.Migrate(ctx); err != nil {
+			t.Fatalf("migration %d failed: %v", i+1, err)
+		}
+	}
+
+	applied := 0
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM schema_migrations").Scan(&applied); err != nil {
+		t.Fatal(err)
+	}
+
+	if applied != len(migrations) {
+		t.Fatalf("applied %d migrations, want %d", applied, len(migrations))
+	}
+
+	publishSections(t, p, testSections)
+
+	t.Run("filters", func(t *testing.T) { testFilters(t, p) })
+	t.Run("keyset windows", func(t *testing.T) { testKeysetWindows(t, p) })
+	t.Run("keys of arrays", func(t *testing.T) { testKeysOfArrays(t, p) })
+	t.Run("courses", func(t *testing.T) { testCourses(t, p) })
+	t.Run("snapshot", func(t *testing.T) { testSnapshot(t, p) })
+
+	t.Run("sections written again", func(t *testing.T) {
+		gen, err := p.NewGeneration(ctx, "again")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed := testSection("a", "CS", 1026, 1, "LEC", 1, "F")
+		for _, sections := range [][]model.Section{testSections, {changed}} {
+			if err := p.WriteSections(ctx, gen.Number, sections); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := p.PublishGeneration(ctx, gen.Number); err != nil {
+			t.Fatal(err)
+		}
+
+		cur, err := p.FindSections(ctx, Query{Filter: Filter{Conditions: []Condition{{"sectionData.times.days", Eq, "F"}}}, Sort: []string{"_id"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The section replaced keeps its place before the section written after it
+		if got, want := classNumbers(t, cur), []int{1, 2}; !reflect.DeepEqual(got, want) {
+			t.Errorf("found %v, want %v", got, want)
+		}
+	})
+
+	t.Run("runs", func(t *testing.T) {
+		started := time.Now().UTC().Truncate(time.Millisecond)
+		runs := []model.Run{
+			{ID: "again", Status: model.RunPublished, Started: started, Problems: []string{}, Checkpoints: []model.Checkpoint{}},
+			{ID: "running", Status: model.RunRunning, Started: started.Add(time.Minute), Checkpoints: []model.Checkpoint{{Subject: "CS", Sections: 3}}},
+			{ID: "targeted", Status: model.RunRunning, Started: started.Add(2 * time.Minute), Targets: []string{"CS"}},
+		}
+
+		for _, run := range runs {
+			if err := p.SaveRun(ctx, run); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if run, err := p.LatestRun(ctx); err != nil || run.ID != "again" || !run.Started.Equal(started) {
+			t.Errorf("latest run is %+v with %v, want run again", run, err)
+		}
+
+		// A targeted run started later does not hide the full run
+		if run, err := p.UnfinishedRun(ctx, false); err != nil || !reflect.DeepEqual(run.Checkpoints, runs[1].Checkpoints) {
+			t.Errorf("unfinished run is %+v with %v, want run running", run, err)
+		}
+
+		if run, err := p.UnfinishedRun(ctx, true); err != nil || run.ID != "targeted" || !reflect.DeepEqual(run.Targets, runs[2].Targets) {
+			t.Errorf("unfinished targeted run is %+v with %v, want run targeted", run, err)
+		}
+	})
+
+	t.Run("options", func(t *testing.T) {
+		gens, err := p.Generations(ctx)
+		if err != nil || len(gens) == 0 {
+			t.Fatalf("found generations %v with %v", gens, err)
+		}
+
+		opts := []model.Option{{Data: model.OptionData{Value: "CS"}}, {Data: model.OptionData{Value: "MATH"}}}
+		if err := p.WriteOptions(ctx, gens[0].Number, "subjects", opts); err != nil {
+			t.Fatal(err)
+		}
+
+		n, err := p.CountOptions(ctx, "subjects", Filter{Conditions: []Condition{{"data.value", Gt, "CS"}}})
+		if err != nil || n != 1 {
+			t.Errorf("counted %d options with %v, want 1", n, err)
+		}
+	})
+
+	t.Run("leases", func(t *testing.T) {
+		if acquired, err := p.AcquireLease(ctx, "test", "one", time.Minute); err != nil || !acquired {
+			t.Fatalf("acquired %v with %v", acquired, err)
+		}
+
+		if acquired, err := p.AcquireLease(ctx, "test", "two", time.Minute); err != nil || acquired {
+			t.Errorf("another holder acquired %v with %v", acquired, err)
+		}
+
+		if err := p.ReleaseLease(ctx, "test", "one"); err != nil {
+			t.Fatal(err)
+		}
+
+		if acquired, err := p.AcquireLease(ctx, "test", "two", time.Minute); err != nil || !acquired {
+			t.Errorf("acquired released lease %v with %v", acquired, err)
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op compares the value of a field with another value
+type Op string
+
+// Comparison operators of a condition
+const (
+	Eq  Op = "eq"
+	Ne  Op = "ne"
+	Gt  Op = "gt"
+	Gte Op = "gte"
+	Lt  Op = "lt"
+	Lte Op = "lte"
+)
+
+// Condition compares the field at a dotted path, e.g. courseData.number, with a value.
+// A path through an array matches if any element matches, except for Ne which only matches if no element is equal
+type Condition struct {
+	Field string
+	Op    Op
+	Value interface{}
+}
+
+// Filter matches documents meeting all of its conditions and nested filters, or any of them when Any is set.
+// An empty filter matches every document
+type Filter struct {
+	Any        bool
+	Conditions []Condition
+	Filters    []Filter
+}
+
+// Empty reports if the filter matches every document
+func (f Filter) Empty() bool {
+	return len(f.Conditions) == 0 && len(f.Filters) == 0
+}
+
+// And combines filters into one that matches documents meeting all of them
+func And(filters ...Filter) Filter {
+	result := Filter{}
+	for _, f := range filters {
+		if !f.Empty() {
+			result.Filters = append(result.Filters, f)
+		}
+	}
+
+	if len(result.Filters) == 1 {
+		return result.Filters[0]
+	}
+
+	return result
+}
+
+// rename returns a copy of the filter with the named fields replaced
+func (f Filter) rename(names map[string]string) Filter {
+	result := Filter{Any: f.Any}
+
+	for _, c := range f.Conditions {
+		if name, ok := names[c.Field]; ok {
+			c.Field = name
+		}
+
+		result.Conditions = append(result.Conditions, c)
+	}
+
+	for _, nested := range f.Filters {
+		result.Filters = append(result.Filters, nested.rename(names))
+	}
+
+	return result
+}
+
+// Query selects a page of documents
+type Query struct {
+	Filter Filter
+	// Sort keys in priority order, all sorted in the same direction
+	Sort []string
+	// Desc sorts descending instead of ascending
+	Desc bool
+
+	Skip int64
+	// Limit of documents to return, 0 for no limit
+	Limit int64
+
+	// Fields to return by dotted path, nil for every field
+	Fields []string
+}
+
+// CourseQuery selects a page of courses grouped from the sections matching a filter.
+// The filter and sort of the query apply to courses; a section field in them, e.g. sectionData.number,
+// stands for the lowest value among the matching sections of a course
+type CourseQuery struct {
+	Query
+
+	// Sections selects the sections grouped into courses
+	Sections Filter
+	// AllSections returns every section of a course instead of only the matching ones
+	AllSections bool
+	// Highest uses the highest value of section fields instead of the lowest, e.g. for courses sorted descending
+	Highest bool
+}
+
+// sectionKeys names the course field holding the value of each section sort key of the query
+func (q CourseQuery) sectionKeys() map[string]string {
+	names := map[string]string{}
+	for i, key := range q.Sort {
+		if strings.HasPrefix(key, "sectionData.") {
+			names[key] = fmt.Sprintf("sortKey%d", i)
+		}
+	}
+
+	return names
+}
+
+// courseQuery returns the query of the grouped courses, with section sort keys replaced by their course fields
+func (q CourseQuery) courseQuery() Query {
+	names := q.sectionKeys()
+
+	result := q.Query
+	result.Filter = q.Filter.rename(names)
+	result.Sort = make([]string, len(q.Sort))
+
+	for i, key := range q.Sort {
+		if name, ok := names[key]; ok {
+			key = name
+		}
+
+		result.Sort[i] = key
+	}
+
+	return result
+}
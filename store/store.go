@@ -0,0 +1,121 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+	"uwo-tt-api/model"
+)
+
+// ErrNotFound is returned when a single requested document does not exist
+var ErrNotFound = errors.New("Document not found")
+
+// Cursor iterates over the documents found by a query
+type Cursor interface {
+	Next(ctx context.Context) bool
+	Decode(v interface{}) error
+	Err() error
+	Close(ctx context.Context) error
+}
+
+// OptionReader reads the form options of each option collection, e.g. subjects
+type OptionReader interface {
+	// CountOptions counts the options of a collection matching a filter
+	CountOptions(ctx context.Context, name string, filter Filter) (int64, error)
+	// FindOptions finds the options of a collection selected by a query
+	FindOptions(ctx context.Context, name string, q Query) (Cursor, error)
+	// OptionKeys finds the values of the sort keys of the options selected by a query, in order.
+	// A value that cannot be compared in a filter, such as an array or a missing field, is nil
+	OptionKeys(ctx context.Context, name string, q Query) ([][]interface{}, error)
+}
+
+// OptionRepository stores the form options scraped into each option collection
+type OptionRepository interface {
+	OptionReader
+
+	// WriteOptions writes every option of a collection into a generation, replacing those already written
+	WriteOptions(ctx context.Context, generation int64, name string, opts []model.Option) error
+}
+
+// SectionReader reads course sections, and the courses they make up
+type SectionReader interface {
+	// CountSections counts the sections matching a filter
+	CountSections(ctx context.Context, filter Filter) (int64, error)
+	// FindSections finds the sections selected by a query
+	FindSections(ctx context.Context, q Query) (Cursor, error)
+	// SectionKeys finds the values of the sort keys of the sections selected by a query, like OptionKeys
+	SectionKeys(ctx context.Context, q Query) ([][]interface{}, error)
+
+	// CountCourses counts the courses with a section matching a filter
+	CountCourses(ctx context.Context, filter Filter) (int64, error)
+	// FindCourses finds the courses selected by a query
+	FindCourses(ctx context.Context, q CourseQuery) (Cursor, error)
+	// CourseKeys finds the values of the sort keys of the courses selected by a query, like OptionKeys
+	CourseKeys(ctx context.Context, q CourseQuery) ([][]interface{}, error)
+}
+
+// SectionRepository stores course sections
+type SectionRepository interface {
+	SectionReader
+
+	// WriteSections writes sections into a generation in order, e.g. those of a subject. A section replaces the section
+	// of the generation with the same id in place; sections without an id are always added
+	WriteSections(ctx context.Context, generation int64, sections []model.Section) error
+}
+
+// Snapshot reads a single generation, whatever is published after it was taken, so that the queries of a request all
+// see the same data
+type Snapshot interface {
+	OptionReader
+	SectionReader
+
+	// Run finds the run that wrote the generation, or ErrNotFound if there is none
+	Run(ctx context.Context) (model.Run, error)
+}
+
+// GenerationRepository stores numbered generations of the data. Reads only see the live generation; a new generation is
+// written while the live one is served, then published across every collection at once
+type GenerationRepository interface {
+	// Snapshot takes a snapshot of the live generation
+	Snapshot(ctx context.Context) (Snapshot, error)
+	// NewGeneration starts an empty generation for the data written by a run
+	NewGeneration(ctx context.Context, run string) (model.Generation, error)
+	// Generations lists the stored generations, newest first
+	Generations(ctx context.Context) ([]model.Generation, error)
+	// PublishGeneration makes a generation live in place of the live generation
+	PublishGeneration(ctx context.Context, number int64) error
+	// DeleteGeneration deletes a generation that is not live
+	DeleteGeneration(ctx context.Context, number int64) error
+}
+
+// RunRepository records the scrape runs that publish data
+type RunRepository interface {
+	// SaveRun creates or updates a run
+	SaveRun(ctx context.Context, run model.Run) error
+	// LatestRun finds the run that wrote the live generation, or ErrNotFound if there is none
+	LatestRun(ctx context.Context) (model.Run, error)
+	// UnfinishedRun finds the most recently started run that is still running among the targeted runs, which only scrape
+	// a few subjects, or among the full runs, or ErrNotFound if there is none
+	UnfinishedRun(ctx context.Context, targeted bool) (model.Run, error)
+}
+
+// LeaseRepository holds named leases, so that only one instance at a time does a task such as scraping
+type LeaseRepository interface {
+	// AcquireLease takes the named lease for a holder, or renews it if the holder has it already, until ttl has passed.
+	// It reports false while another holder has the lease and it has not expired
+	AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error)
+	// ReleaseLease gives up the named lease if the holder has it
+	ReleaseLease(ctx context.Context, name string, holder string) error
+}
+
+// Repository stores everything the API serves and the scraper publishes
+type Repository interface {
+	OptionRepository
+	SectionRepository
+	GenerationRepository
+	RunRepository
+	LeaseRepository
+
+	// Ping checks that the database can be reached
+	Ping(ctx context.Context) error
+}
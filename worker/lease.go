@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/store"
+
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ScrapeLease is the lease held by the instance that scrapes, so that only one instance scrapes at a time
+const ScrapeLease = "scrape"
+
+// Instance identifies this instance as the holder of leases
+var Instance = newInstance()
+
+// running holds the names of the leases whose task this instance is running
+var running sync.Map
+
+// newInstance names the instance after its host and process, made unique in case they are reused
+func newInstance() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), primitive.NewObjectID().Hex())
+}
+
+// WithLease runs a task while holding the named lease, renewing it every third of ttl until the task is done. It reports false
+// without running the task if another instance holds the lease, or this instance is running a task with it already. If this
+// instance dies, the lease expires after ttl and another instance can take it over. The context of the task is cancelled
+// with ctx, or once the lease is lost or could expire as it was not renewed, so that two instances never run the task at once.
+// The lease is released even if ctx is cancelled while the task runs, so that a stopped instance hands it over right away
+func WithLease(ctx context.Context, repo store.LeaseRepository, name string, ttl time.Duration, task func(ctx context.Context)) (bool, error) {
+	lease, err := TakeLease(ctx, repo, name, ttl)
+	if lease == nil {
+		return false, err
+	}
+
+	lease.Run(ctx, task)
+
+	return true, nil
+}
+
+// Lease is a lease taken by this instance for a task, held until the task is done
+type Lease struct {
+	repo store.LeaseRepository
+	name string
+	ttl  time.Duration
+}
+
+// TakeLease takes the named lease for a task run later with Run, so a caller knows right away if the task can run.
+// It returns nil if another instance holds the lease, or this instance is running a task with it already
+func TakeLease(ctx context.Context, repo store.LeaseRepository, name string, ttl time.Duration) (*Lease, error) {
+	if _, busy := running.LoadOrStore(name, true); busy {
+		return nil, nil
+	}
+
+	acquired, err := repo.AcquireLease(ctx, name, Instance, ttl)
+	if err != nil || !acquired {
+		running.Delete(name)
+		return nil, err
+	}
+
+	return &Lease{repo: repo, name: name, ttl: ttl}, nil
+}
+
+// Run runs a task while holding the lease like WithLease, then releases it. A lease runs a single task
+func (l *Lease) Run(ctx context.Context, task func(ctx context.Context)) {
+	defer running.Delete(l.name)
+
+	log := logger.From(ctx).WithField("lease", l.name)
+
+	taskCtx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	done := make(chan struct{})
+	go renewLease(l.repo, l.name, l.ttl, log, done, stop)
+
+	task(taskCtx)
+
+	close(done)
+	release, cancel := context.WithTimeout(context.Background(), l.ttl)
+	defer cancel()
+
+	if err := l.repo.ReleaseLease(release, l.name, Instance); err != nil {
+		log.WithError(err).Error("Failed to release lease")
+	}
+}
+
+// renewLease renews the named lease until done is closed, logging failures to log. It stops the task once the lease is lost,
+// or once it was not renewed for long enough that it could expire before the next renewal
+func renewLease(repo store.LeaseRepository, name string, ttl time.Duration, log *logrus.Entry, done chan struct{}, stop func()) {
+	interval := ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	renewed := time.Now()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		// A hung database must not hold up renewal past the next attempt
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		acquired, err := repo.AcquireLease(ctx, name, Instance, ttl)
+		cancel()
+
+		switch {
+		case err == nil && acquired:
+			renewed = time.Now()
+		case err == nil:
+			log.Warn("Lost lease to another instance, stopping its task")
+			stop()
+			return
+		case time.Since(renewed)+interval >= ttl:
+			log.WithError(err).Error("Failed to renew lease before it could expire, stopping its task")
+			stop()
+			return
+		default:
+			// A failed renewal is retried while the lease lasts until the next attempt
+			log.WithError(err).Warn("Failed to renew lease")
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+	"uwo-tt-api/store"
+)
+
+// flakyLeases grants the first acquisition of a lease, then answers every renewal with renewal
+type flakyLeases struct {
+	store.LeaseRepository
+	calls   int32
+	renewal func(ctx context.Context) (bool, error)
+}
+
+func (l *flakyLeases) AcquireLease(ctx context.Context, name string, holder string, ttl time.Duration) (bool, error) {
+	if atomic.AddInt32(&l.calls, 1) == 1 {
+		return l.LeaseRepository.AcquireLease(ctx, name, holder, ttl)
+	}
+
+	return l.renewal(ctx)
+}
+
+func TestWithLeaseStopsTask(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+
+	tests := []struct {
+		name    string
+		renewal func(ctx context.Context) (bool, error)
+		// within is how long the task may run before it is stopped
+		within time.Duration
+	}{
+		{"lost", func(context.Context) (bool, error) { return false, nil }, ttl},
+		{"renewal fails", func(context.Context) (bool, error) { return false, errors.New("database down") }, ttl},
+		{"database hangs", func(ctx context.Context) (bool, error) { <-ctx.Done(); return false, ctx.Err() }, ttl},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			repo := &flakyLeases{LeaseRepository: store.NewMemory(), renewal: test.renewal}
+
+			start := time.Now()
+			var stopped time.Duration
+
+			ran, err := WithLease(context.Background(), repo, "test", ttl, func(ctx context.Context) {
+				select {
+				case <-ctx.Done():
+					stopped = time.Since(start)
+				case <-time.After(5 * ttl):
+				}
+			})
+
+			if !ran || err != nil {
+				t.Fatalf("ran %v with %v, want the task to run", ran, err)
+			}
+
+			if stopped == 0 || stopped > test.within {
+				t.Errorf("task stopped after %s, want it stopped within %s", stopped, test.within)
+			}
+		})
+	}
+}
+
+func TestWithLeaseKeepsRenewedTask(t *testing.T) {
+	const ttl = 300 * time.Millisecond
+
+	repo := store.NewMemory()
+	ran, err := WithLease(context.Background(), repo, "test", ttl, func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			t.Error("task stopped while the lease was renewed")
+		case <-time.After(3 * ttl):
+		}
+
+		if acquired, _ := repo.AcquireLease(ctx, "test", "other", ttl); acquired {
+			t.Error("another instance took the lease while it was renewed")
+		}
+	})
+
+	if !ran || err != nil {
+		t.Fatalf("ran %v with %v, want the task to run", ran, err)
+	}
+}
+
+func TestTakeLease(t *testing.T) {
+	const ttl = time.Minute
+
+	ctx := context.Background()
+	repo := store.NewMemory()
+
+	lease, err := TakeLease(ctx, repo, "test", ttl)
+	if lease == nil || err != nil {
+		t.Fatalf("took %v with %v, want the lease", lease, err)
+	}
+
+	if again, err := TakeLease(ctx, repo, "test", ttl); again != nil || err != nil {
+		t.Errorf("took %v with %v while this instance holds the lease", again, err)
+	}
+
+	if acquired, _ := repo.AcquireLease(ctx, "test", "other", ttl); acquired {
+		t.Error("another instance took the lease before its task ran")
+	}
+
+	ran := false
+	lease.Run(ctx, func(ctx context.Context) { ran = true })
+
+	if !ran {
+		t.Error("task did not run")
+	}
+
+	if acquired, _ := repo.AcquireLease(ctx, "test", "other", ttl); !acquired {
+		t.Error("lease was not released once its task was done")
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/metrics"
 	"uwo-tt-api/model"
 
+	"uwo-tt-api/store"
+
 	"github.com/PuerkitoBio/goquery"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // NumberCol section number column index
@@ -56,8 +59,10 @@ type PageScraper struct {
 	Header string
 	URL    string
 	Status string
-	DB     *mongo.Database
+	Repo   store.Repository
 	Form   *goquery.Selection
+	// Run receives the scraped data into its generation and records the subjects written
+	Run *model.Run
 }
 
 // PageResult encompasses data that is passed into channel to be parsed
@@ -87,9 +92,14 @@ func (page *PageScraper) BuildTimeInfo() model.TimeInfo {
 }
 
 // FetchDocument fetches contents of page based on URL
-func (page *PageScraper) FetchDocument() (document *goquery.Document, err error) {
+func (page *PageScraper) FetchDocument(ctx context.Context) (document *goquery.Document, err error) {
 	// GET request for website contents
-	resp, err := http.Get(page.URL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, page.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -112,9 +122,16 @@ func (page *PageScraper) FetchDocument() (document *goquery.Document, err error)
 }
 
 // PostDocument fetches content from page URL after submitting post request with form data
-func (page *PageScraper) PostDocument(data map[string][]string) (document *goquery.Document, err error) {
+func (page *PageScraper) PostDocument(ctx context.Context, data map[string][]string) (document *goquery.Document, err error) {
 	// POST request for website contents
-	resp, err := http.PostForm(page.URL, data)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, page.URL, strings.NewReader(url.Values(data).Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -133,24 +150,10 @@ func (page *PageScraper) PostDocument(data map[string][]string) (document *goque
 	return doc, nil
 }
 
-// ScrapeOptToDB temp
-func (page *PageScraper) ScrapeOptToDB(collectionName string, selector string, wg *sync.WaitGroup) {
-	defer wg.Done()
-
-	// Connect to temporary collection
-	tempCollection := page.DB.Collection(collectionName + "_temp")
-
-	// Delete all existing documents if recovering from crash or shutdown
-	res, err := tempCollection.DeleteMany(context.TODO(), bson.M{})
-	if err != nil {
-		fmt.Println("DeleteMany() ERROR:", err)
-		return
-	}
-
-	fmt.Printf("Cleared %d documents from temporary %s collection\n", res.DeletedCount, collectionName)
-
+// ScrapeOptions scrapes the options of a form input
+func (page *PageScraper) ScrapeOptions(selector string) []model.Option {
 	// Scraped options, empty map slice
-	opts := []interface{}{}
+	opts := []model.Option{}
 
 	// Find all instances of selector and append it to result
 	page.Form.Find(selector + " option").Each(func(i int, elem *goquery.Selection) {
@@ -167,55 +170,54 @@ func (page *PageScraper) ScrapeOptToDB(collectionName string, selector string, w
 		})
 	})
 
-	// Insert data into a new temporary collection to not affect main collection during scraping
-	insertCtx, err := tempCollection.InsertMany(context.TODO(), opts)
-	if err != nil {
-		fmt.Println(err)
-	}
+	return opts
+}
 
-	// Create aggregation pipeline were first, all data is matched, then all data is written to collectionName
-	// $out replaces data in collection
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{}},
-		bson.M{"$out": collectionName},
-	}
+// ScrapeOptToDB scrapes the options of a form input into a collection of the generation
+func (page *PageScraper) ScrapeOptToDB(ctx context.Context, collectionName string, selector string, wg *sync.WaitGroup) {
+	defer wg.Done()
 
-	// Use aggregation to make sure main collection is never empty
-	startTime := time.Now()
-	tempCollection.Aggregate(context.TODO(), pipeline)
+	opts := page.ScrapeOptions(selector)
 
-	fmt.Printf("%s Inserted: %d. Aggregation time: %s\n",
-		collectionName,
-		len(insertCtx.InsertedIDs),
-		time.Since(startTime).String())
+	log := logger.From(ctx).WithField("collection", collectionName)
 
-	// Drop temporary collection
-	err = tempCollection.Drop(context.TODO())
-	if err != nil {
-		fmt.Println(err)
+	startTime := time.Now()
+	if err := page.Repo.WriteOptions(ctx, page.Run.Generation, collectionName, opts); err != nil {
+		log.WithError(err).Error("Failed to write options")
+		return
 	}
 
+	metrics.DocumentsParsed.WithLabelValues(collectionName).Add(float64(len(opts)))
+
+	log.WithField("duration", time.Since(startTime).Seconds()).Infof("Inserted %d options", len(opts))
 }
 
 // extract information course specific information from goquery selection
-func extractCourseInfo(courseList *goquery.Selection, courseIndex int) model.CourseComponent {
+func extractCourseInfo(courseList *goquery.Selection, courseIndex int) (model.CourseComponent, error) {
 	// Grab header based on course index
 	header := courseList.ChildrenFiltered("h4").Eq(courseIndex).Text()
 
 	// Grab description based on course index
 	desc := courseList.ChildrenFiltered("p").Eq(courseIndex).Text()
 
+	// Header is "<faculty> <number> - <name>"
+	parts := strings.Split(header, "-")
+	code := strings.Split(parts[0], " ")
+	if len(parts) < 2 || len(code) < 2 {
+		return model.CourseComponent{}, fmt.Errorf("Unexpected course header %q", Trim(header))
+	}
+
 	// Course name
-	name := strings.Split(header, "-")[1]
+	name := parts[1]
 
 	// Course faculty
-	faculty := strings.Split(strings.Split(header, "-")[0], " ")[0]
+	faculty := code[0]
 
 	// Course number and suffix
 	// 1000A -> "1000" "A"
 	// 1000 -> "1000" ""
 	suffix := ""
-	number := strings.Split(strings.Split(header, "-")[0], " ")[1]
+	number := code[1]
 	if len(number) == 5 {
 		suffix = string(number[4])
 		number = number[:4]
@@ -223,9 +225,7 @@ func extractCourseInfo(courseList *goquery.Selection, courseIndex int) model.Cou
 
 	num, err := strconv.Atoi(Trim(number))
 	if err != nil {
-		// handle error
-		fmt.Println(err)
-		num = 0
+		return model.CourseComponent{}, fmt.Errorf("Unexpected course number in %q", Trim(header))
 	}
 
 	return model.CourseComponent{
@@ -233,12 +233,18 @@ func extractCourseInfo(courseList *goquery.Selection, courseIndex int) model.Cou
 		Number:      num,
 		Suffix:      Trim(suffix),
 		Name:        Trim(name),
-		Description: Trim(desc)}
+		Description: Trim(desc)}, nil
 }
 
-// extract section specific information from goquery selection
-func extractSectionInfo(section *goquery.Selection) model.SectionComponent {
+// extract section specific information from goquery selection. The section is still returned along with an error
+// when a column could not be parsed
+func extractSectionInfo(section *goquery.Selection) (model.SectionComponent, error) {
 	var s model.SectionComponent
+	var err error
+
+	if columns := section.ChildrenFiltered("td").Length(); columns <= DeliveryCol {
+		return s, fmt.Errorf("Unexpected section row with %d columns", columns)
+	}
 
 	// Filter section into each individual section column component
 	var start string
@@ -250,20 +256,18 @@ func extractSectionInfo(section *goquery.Selection) model.SectionComponent {
 		// k represents index of the table heading; column number
 		switch k {
 		case NumberCol:
-			i, err := strconv.Atoi(Trim(elem.Text()))
-			if err != nil {
-				// handle error
-				fmt.Println(err)
+			i, convErr := strconv.Atoi(Trim(elem.Text()))
+			if convErr != nil {
+				err = fmt.Errorf("Unexpected section number %q", Trim(elem.Text()))
 			} else {
 				s.Number = i
 			}
 		case ComponentCol:
 			s.Component = Trim(elem.Text())
 		case ClassNbrCol:
-			i, err := strconv.Atoi(Trim(elem.Text()))
-			if err != nil {
-				// handle error
-				fmt.Println(err)
+			i, convErr := strconv.Atoi(Trim(elem.Text()))
+			if convErr != nil {
+				err = fmt.Errorf("Unexpected class number %q", Trim(elem.Text()))
 			} else {
 				s.ClassNumber = i
 			}
@@ -297,47 +301,50 @@ func extractSectionInfo(section *goquery.Selection) model.SectionComponent {
 	// Collect days and times
 	for _, day := range days {
 		if day != "" {
-			s.Times = append(s.Times, model.TimeComponent{day, start, end})
+			s.Times = append(s.Times, model.TimeComponent{Day: day, StartTime: start, EndTime: end})
 		}
 	}
 
-	return s
+	return s, err
 }
 
-// ScrapeCoursesToDB scrapes course information from pages incoming into channel and store info in database
-func (page *PageScraper) ScrapeCoursesToDB(c chan PageResult, size int) {
-
-	// Connect to temporary collection
-	tempCollection := page.DB.Collection("courses_temp")
-
-	// Delete all existing documents if recovering from crash
-	res, err := tempCollection.DeleteMany(context.TODO(), bson.M{})
-	if err != nil {
-		fmt.Println("DeleteMany() ERROR:", err)
-		return
-	}
-
-	fmt.Printf("Cleared %d documents from temporary courses collection\n", res.DeletedCount)
+// ScrapeCoursesToDB scrapes course information from pages incoming into channel and stores it in the generation,
+// recording what was found in stats. Once ctx is cancelled the remaining pages are dropped
+func (page *PageScraper) ScrapeCoursesToDB(ctx context.Context, c chan PageResult, size int, stats *ScrapeStats, wg *sync.WaitGroup) {
+	defer wg.Done()
 
 	counter := 1
 	// Iterate over documents in the channel as long as the channel is open
 	for doc := range c {
+		if ctx.Err() != nil {
+			continue
+		}
+
 		courses := doc.Doc.Find(".span12")
+		log := logger.From(ctx).WithField("subject", doc.Name)
 
-		// Size-1 to account for the ANY element
-		fmt.Printf("Scraping - #%d: %s - %.2f%%\n", counter, doc.Name, float32((float32(counter)/float32(size-1))*100.00))
+		log.Infof("Scraping - #%d - %.2f%%", counter, float32((float32(counter)/float32(size))*100.00))
 		counter++
 
+		// Rows of the page, merged into sections once the page is parsed
+		rows := []model.Section{}
+
 		// Filter course list into each individual course table
 		courses.ChildrenFiltered("table").Each(func(i int, course *goquery.Selection) {
 
 			// Course info and section info are not grouped into a div so need to match table to header/p with index
-			courseData := extractCourseInfo(courses, i)
+			courseData, err := extractCourseInfo(courses, i)
+			if err != nil {
+				log.WithError(err).Warn("Failed to parse course")
+				stats.AddError(doc.Name)
+				metrics.SubjectErrors.WithLabelValues("parse").Inc()
+				return
+			}
 
 			// Filter course into each individual course section
 			course.ChildrenFiltered("tbody").ChildrenFiltered("tr").Each(func(_ int, section *goquery.Selection) {
 
-				sectionData := extractSectionInfo(section)
+				sectionData, err := extractSectionInfo(section)
 
 				courseSection := model.Section{
 					Source:      page.BuildSourceInfo(),
@@ -346,49 +353,52 @@ func (page *PageScraper) ScrapeCoursesToDB(c chan PageResult, size int) {
 					SectionData: sectionData,
 				}
 
-				// Update all components with the section information and component by adding new time information
-				query := bson.M{"$and": bson.A{
-					bson.M{"courseData": courseData},
-					bson.M{"sectionData.number": sectionData.Number},
-					bson.M{"sectionData.component": sectionData.Component}}}
-
-				changes := bson.M{"$push": bson.M{"sectionData.times": bson.M{"$each": sectionData.Times}}}
-
-				// Update
-				updateResult, err := tempCollection.UpdateMany(context.TODO(), query, changes)
+				// A row that could not be parsed has no id, so it is never mistaken for another section
 				if err != nil {
-					if len(sectionData.Times) != 0 {
-						fmt.Println(err)
-					}
+					log.WithError(err).Warn("Failed to parse section")
+					stats.AddError(doc.Name)
+					metrics.SubjectErrors.WithLabelValues("parse").Inc()
+				} else {
+					courseSection.ID = model.SectionID(courseSection.Source, sectionData.ClassNumber, sectionData.Component)
 				}
 
-				// If no modifications were made, insert the document
-				if updateResult.ModifiedCount == 0 {
-					_, insertErr := tempCollection.InsertOne(context.TODO(), courseSection)
-					if insertErr != nil {
-						fmt.Println(insertErr)
-					}
-				}
+				rows = append(rows, courseSection)
 			})
 		})
-	}
 
-	// TODO: Refractor out because used twice
-	// Create aggregation pipeline where first, all data is matched, then all data is written to collectionName
-	pipeline := bson.A{
-		bson.M{"$match": bson.M{}},
-		bson.M{"$out": "courses"},
+		// Write all sections of the subject at once
+		sections := mergeSections(rows)
+		if err := page.Repo.WriteSections(ctx, page.Run.Generation, sections); err != nil {
+			log.WithError(err).Error("Error writing subject")
+			metrics.SubjectErrors.WithLabelValues("write").Inc()
+			stats.AddFailed(doc.Name)
+			continue
+		}
+
+		metrics.DocumentsParsed.WithLabelValues("courses").Add(float64(len(sections)))
+
+		// Record the subject as done so it is not scraped again if the run is interrupted
+		page.Run.Checkpoints = append(page.Run.Checkpoints, stats.AddSubject(doc.Name, sections))
+		if err := page.Repo.SaveRun(ctx, *page.Run); err != nil {
+			log.WithError(err).Error("Failed to record checkpoint")
+		}
 	}
+}
 
-	// Use aggregation to make sure main collection is never empty
-	startTime := time.Now()
-	tempCollection.Aggregate(context.TODO(), pipeline)
+// mergeSections merges the rows of a page into sections. A row continuing a section, with the same id, only adds its meeting times to it
+func mergeSections(rows []model.Section) []model.Section {
+	sections := []model.Section{}
+	merged := map[string]int{}
 
-	fmt.Printf("Course aggregation time: %s\n", time.Since(startTime).String())
+	for _, row := range rows {
+		if i, ok := merged[row.ID]; ok && row.ID != "" {
+			sections[i].SectionData.Times = append(sections[i].SectionData.Times, row.SectionData.Times...)
+			continue
+		}
 
-	// Drop temporary collection
-	err = tempCollection.Drop(context.TODO())
-	if err != nil {
-		fmt.Println(err)
+		merged[row.ID] = len(sections)
+		sections = append(sections, row)
 	}
+
+	return sections
 }
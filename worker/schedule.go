@@ -0,0 +1,303 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/store"
+
+	"github.com/robfig/cron/v3"
+)
+
+// maxSkips bounds how many date ranges are skipped looking for the next scrape, so a schedule that never runs ends
+const maxSkips = 10
+
+// Schedule decides when scrapes run. The default profile runs full scrapes, while date-ranged profiles add scrapes of their
+// subjects. A profile without subjects replaces the default profile while it is active
+type Schedule struct {
+	Default  Profile
+	Profiles []Profile
+	// Location the cron expressions and dates are in
+	Location *time.Location
+}
+
+// Profile schedules scrapes with a cron expression, only from its first to its last day of every year if it has them
+type Profile struct {
+	Spec     string
+	Subjects []string
+	// From and To are the first and last day the profile is active. A profile without them is always active
+	From, To Day
+
+	schedule cron.Schedule
+}
+
+// Day is a day of every year
+type Day struct {
+	Month time.Month
+	Day   int
+}
+
+// ScheduledRun is a scrape run by the schedule, of only its subjects if it has any
+type ScheduledRun struct {
+	Time     time.Time
+	Subjects []string
+}
+
+// ParseSchedule parses the cron expression of full scrapes and the date-ranged profiles, separated by ";". A profile is written as
+// MM-DD..MM-DD|cron expression|SUBJECT,SUBJECT, leaving out the subjects to replace full scrapes, e.g. 09-01..09-15|@hourly|COMPSCI,MATH
+func ParseSchedule(spec string, profiles string, loc *time.Location) (Schedule, error) {
+	schedule := Schedule{Location: loc}
+
+	def, err := parseProfile(spec)
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.Default = def
+
+	for _, text := range strings.Split(profiles, ";") {
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+
+		parts := strings.Split(text, "|")
+		if len(parts) < 2 || len(parts) > 3 {
+			return schedule, fmt.Errorf("Invalid schedule profile %q", text)
+		}
+
+		profile, err := parseProfile(parts[1])
+		if err != nil {
+			return schedule, err
+		}
+
+		days := strings.Split(strings.TrimSpace(parts[0]), "..")
+		if len(days) != 2 {
+			return schedule, fmt.Errorf("Invalid dates %q of schedule profile", parts[0])
+		}
+
+		if profile.From, err = parseDay(days[0]); err != nil {
+			return schedule, err
+		}
+
+		if profile.To, err = parseDay(days[1]); err != nil {
+			return schedule, err
+		}
+
+		if len(parts) == 3 {
+			for _, subject := range strings.Split(parts[2], ",") {
+				if subject = strings.ToUpper(strings.TrimSpace(subject)); subject != "" {
+					profile.Subjects = append(profile.Subjects, subject)
+				}
+			}
+		}
+
+		schedule.Profiles = append(schedule.Profiles, profile)
+	}
+
+	return schedule, nil
+}
+
+// parseProfile parses the cron expression of a profile, with five fields or a descriptor such as @daily
+func parseProfile(spec string) (Profile, error) {
+	spec = strings.TrimSpace(spec)
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return Profile{}, fmt.Errorf("Invalid schedule %q: %w", spec, err)
+	}
+
+	return Profile{Spec: spec, schedule: schedule}, nil
+}
+
+// parseDay parses a day of every year written as MM-DD
+func parseDay(text string) (Day, error) {
+	t, err := time.Parse("01-02", strings.TrimSpace(text))
+	if err != nil {
+		return Day{}, fmt.Errorf("Invalid day %q, expected MM-DD", text)
+	}
+
+	return Day{Month: t.Month(), Day: t.Day()}, nil
+}
+
+// next finds the first midnight of the day after a time
+func (d Day) next(after time.Time) time.Time {
+	t := time.Date(after.Year(), d.Month, d.Day, 0, 0, 0, 0, after.Location())
+	if !t.After(after) {
+		t = time.Date(after.Year()+1, d.Month, d.Day, 0, 0, 0, 0, after.Location())
+	}
+
+	return t
+}
+
+// number orders the days of a year
+func (d Day) number() int {
+	return int(d.Month)*100 + d.Day
+}
+
+// dated reports if the profile is only active between its days
+func (p Profile) dated() bool {
+	return p.From.Month != 0
+}
+
+// active reports if the profile is active at a time, including ranges across the new year such as 12-20..01-05
+func (p Profile) active(t time.Time) bool {
+	if !p.dated() {
+		return true
+	}
+
+	day := Day{Month: t.Month(), Day: t.Day()}.number()
+	if p.From.number() <= p.To.number() {
+		return day >= p.From.number() && day <= p.To.number()
+	}
+
+	return day >= p.From.number() || day <= p.To.number()
+}
+
+// end finds the midnight after the last day of the range a time is in
+func (p Profile) end(t time.Time) time.Time {
+	return Day{Month: p.To.Month, Day: p.To.Day + 1}.next(t)
+}
+
+// next finds the first scrape of the profile after a time, or a zero time if there is none
+func (p Profile) next(after time.Time) time.Time {
+	t := p.schedule.Next(after)
+
+	for i := 0; i < maxSkips && !t.IsZero(); i++ {
+		if p.active(t) {
+			return t
+		}
+
+		// Continue from the start of the next range
+		t = p.schedule.Next(p.From.next(t).Add(-time.Second))
+	}
+
+	return time.Time{}
+}
+
+// replacing finds the active profile without subjects that replaces the default profile at a time
+func (s Schedule) replacing(t time.Time) *Profile {
+	for i, profile := range s.Profiles {
+		if len(profile.Subjects) == 0 && profile.active(t) {
+			return &s.Profiles[i]
+		}
+	}
+
+	return nil
+}
+
+// nextDefault finds the first scrape of the default profile after a time while no profile replaces it
+func (s Schedule) nextDefault(after time.Time) time.Time {
+	t := s.Default.schedule.Next(after)
+
+	for i := 0; i < maxSkips && !t.IsZero(); i++ {
+		profile := s.replacing(t)
+		if profile == nil {
+			return t
+		}
+
+		// Continue from the end of the replacing range
+		t = s.Default.schedule.Next(profile.end(t).Add(-time.Second))
+	}
+
+	return time.Time{}
+}
+
+// Next finds the first scrape after a time. Scrapes at the same time are combined, a full scrape including every subject
+func (s Schedule) Next(after time.Time) (ScheduledRun, bool) {
+	after = after.In(s.Location)
+
+	run := ScheduledRun{Time: s.nextDefault(after)}
+	full := !run.Time.IsZero()
+
+	for _, profile := range s.Profiles {
+		t := profile.next(after)
+
+		switch {
+		case t.IsZero():
+			continue
+		case run.Time.IsZero() || t.Before(run.Time):
+			run = ScheduledRun{Time: t, Subjects: profile.Subjects}
+			full = len(profile.Subjects) == 0
+		case t.Equal(run.Time) && !full:
+			if len(profile.Subjects) == 0 {
+				run.Subjects, full = nil, true
+			} else {
+				run.Subjects = union(run.Subjects, profile.Subjects)
+			}
+		}
+	}
+
+	return run, !run.Time.IsZero()
+}
+
+// Upcoming lists the next n scrapes after a time
+func (s Schedule) Upcoming(after time.Time, n int) []ScheduledRun {
+	runs := []ScheduledRun{}
+
+	for len(runs) < n {
+		run, ok := s.Next(after)
+		if !ok {
+			break
+		}
+
+		runs = append(runs, run)
+		after = run.Time
+	}
+
+	return runs
+}
+
+// Run runs scrapes as they are scheduled, one at a time, until ctx is cancelled. A scrape missed while another is running
+// is skipped
+func (s Schedule) Run(ctx context.Context, scrape func(ScheduledRun)) {
+	for {
+		run, ok := s.Next(time.Now())
+		if !ok {
+			logger.From(ctx).Warn("No scrapes are scheduled")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(run.Time)):
+		}
+
+		scrape(run)
+	}
+}
+
+// ScrapeDue reports if a scrape should run right away: when an interrupted run can be resumed, nothing is published yet or
+// the live data is older than fresh
+func ScrapeDue(ctx context.Context, repo store.Repository, fresh time.Duration) bool {
+	if Interrupted(ctx, repo) {
+		return true
+	}
+
+	live, err := repo.LatestRun(ctx)
+	if err != nil {
+		return true
+	}
+
+	return time.Since(live.Finished) >= fresh
+}
+
+// union combines two lists of subjects, sorted
+func union(a []string, b []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+
+	for _, subject := range append(append([]string{}, a...), b...) {
+		if !seen[subject] {
+			seen[subject] = true
+			result = append(result, subject)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
@@ -0,0 +1,241 @@
+package worker
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+)
+
+// at parses a time of the tests, in UTC
+func at(t *testing.T, text string) time.Time {
+	result, err := time.Parse("2006-01-02 15:04", text)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return result
+}
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec     string
+		profiles string
+		want     []Profile
+		invalid  bool
+	}{
+		{"@daily", "", nil, false},
+		{"@daily", " ; ", nil, false},
+		{"@daily", "09-01..09-15|@hourly|compsci, MATH,", []Profile{{
+			Spec: "@hourly", Subjects: []string{"COMPSCI", "MATH"}, From: Day{time.September, 1}, To: Day{time.September, 15},
+		}}, false},
+		{"@daily", "12-20..01-05|0 12 * * *", []Profile{{
+			Spec: "0 12 * * *", From: Day{time.December, 20}, To: Day{time.January, 5},
+		}}, false},
+		{"every day", "", nil, true},
+		{"@daily", "09-01..09-15", nil, true},
+		{"@daily", "09-01..09-15|@hourly|CS|MATH", nil, true},
+		{"@daily", "09-01|@hourly", nil, true},
+		{"@daily", "09-31..10-15|@hourly", nil, true},
+		{"@daily", "9/1..9/15|@hourly", nil, true},
+		{"@daily", "09-01..09-15|hourly", nil, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.spec+" "+test.profiles, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec, test.profiles, time.UTC)
+			if test.invalid {
+				if err == nil {
+					t.Errorf("parsed %+v, want an error", schedule)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Parsed cron schedules are left out of the comparison
+			got := []Profile{}
+			for _, profile := range schedule.Profiles {
+				profile.schedule = nil
+				got = append(got, profile)
+			}
+
+			if test.want == nil {
+				test.want = []Profile{}
+			}
+
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parsed profiles %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestProfileActive(t *testing.T) {
+	tests := []struct {
+		profiles string
+		time     string
+		want     bool
+	}{
+		{"09-01..09-15|@hourly", "2020-08-31 23:59", false},
+		{"09-01..09-15|@hourly", "2020-09-01 00:00", true},
+		{"09-01..09-15|@hourly", "2020-09-15 23:59", true},
+		{"09-01..09-15|@hourly", "2020-09-16 00:00", false},
+		{"09-01..09-01|@hourly", "2020-09-01 12:00", true},
+		{"09-01..09-01|@hourly", "2020-09-02 00:00", false},
+		// A range across the new year is active at the end of one year and the start of the next
+		{"12-20..01-05|@hourly", "2020-12-19 23:59", false},
+		{"12-20..01-05|@hourly", "2020-12-20 00:00", true},
+		{"12-20..01-05|@hourly", "2020-12-31 23:59", true},
+		{"12-20..01-05|@hourly", "2021-01-01 00:00", true},
+		{"12-20..01-05|@hourly", "2021-01-05 23:59", true},
+		{"12-20..01-05|@hourly", "2021-01-06 00:00", false},
+		{"12-20..01-05|@hourly", "2021-07-01 00:00", false},
+		{"02-29..03-01|@hourly", "2020-02-29 12:00", true},
+		{"02-29..03-01|@hourly", "2021-02-28 12:00", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.profiles+" "+test.time, func(t *testing.T) {
+			schedule, err := ParseSchedule("@daily", test.profiles, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := schedule.Profiles[0].active(at(t, test.time)); got != test.want {
+				t.Errorf("active is %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		profiles string
+		after    string
+		want     string
+		subjects []string
+	}{
+		{"full scrape", "@daily", "", "2020-09-10 12:00", "2020-09-11 00:00", nil},
+		{"subjects in range", "@daily", "09-01..09-15|@hourly|CS,MATH", "2020-09-10 12:00", "2020-09-10 13:00", []string{"CS", "MATH"}},
+		{"subjects out of range", "@daily", "09-01..09-15|@hourly|CS", "2020-10-10 12:00", "2020-10-11 00:00", nil},
+		// A full scrape at the same time as a scrape of subjects includes them
+		{"first day of range", "@daily", "09-01..09-15|@hourly|CS", "2020-08-31 23:30", "2020-09-01 00:00", nil},
+		{"last hour of range", "@daily", "09-01..09-15|@hourly|CS", "2020-09-15 22:30", "2020-09-15 23:00", []string{"CS"}},
+		{"after last day of range", "@daily", "09-01..09-15|@hourly|CS", "2020-09-15 23:30", "2020-09-16 00:00", nil},
+		{"range skipped to next year", "@yearly", "09-01..09-15|@hourly|CS", "2020-09-15 23:30", "2021-01-01 00:00", nil},
+		{"range of next year", "0 0 1 7 *", "09-01..09-15|@hourly|CS", "2020-09-15 23:30", "2021-07-01 00:00", nil},
+		{"range before full scrape", "0 0 1 10 *", "09-01..09-15|@hourly|CS", "2020-09-15 23:30", "2020-10-01 00:00", nil},
+		{"subjects combined", "@daily", "09-01..09-15|0 */6 * * *|MATH,CS;09-01..09-30|0 12 * * *|BIO,CS", "2020-09-10 07:00",
+			"2020-09-10 12:00", []string{"BIO", "CS", "MATH"}},
+		// A profile without subjects replaces full scrapes, here across the new year
+		{"replaced", "@daily", "12-20..01-05|0 12 * * *", "2020-12-31 06:00", "2020-12-31 12:00", nil},
+		{"replaced from first day", "@daily", "12-20..01-05|0 12 * * *", "2020-12-19 23:00", "2020-12-20 12:00", nil},
+		{"replaced in new year", "@daily", "12-20..01-05|0 12 * * *", "2021-01-04 13:00", "2021-01-05 12:00", nil},
+		{"back after replacing range", "@daily", "12-20..01-05|0 12 * * *", "2021-01-05 13:00", "2021-01-06 00:00", nil},
+		{"replaced while subjects scraped", "@daily", "12-20..01-05|0 12 * * 1;12-20..01-05|0 */6 * * *|CS", "2020-12-21 07:00",
+			"2020-12-21 12:00", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			schedule, err := ParseSchedule(test.spec, test.profiles, time.UTC)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			run, ok := schedule.Next(at(t, test.after))
+			if !ok {
+				t.Fatal("no scrape is scheduled")
+			}
+
+			if want := at(t, test.want); !run.Time.Equal(want) {
+				t.Errorf("next scrape is at %s, want %s", run.Time, want)
+			}
+
+			if !reflect.DeepEqual(run.Subjects, test.subjects) {
+				t.Errorf("next scrape is of %v, want %v", run.Subjects, test.subjects)
+			}
+		})
+	}
+}
+
+func TestScheduleUpcoming(t *testing.T) {
+	schedule, err := ParseSchedule("@daily", "09-01..09-15|0 */8 * * *|CS", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ScheduledRun{
+		{Time: at(t, "2020-09-14 16:00"), Subjects: []string{"CS"}},
+		{Time: at(t, "2020-09-15 00:00")},
+		{Time: at(t, "2020-09-15 08:00"), Subjects: []string{"CS"}},
+		{Time: at(t, "2020-09-15 16:00"), Subjects: []string{"CS"}},
+		{Time: at(t, "2020-09-16 00:00")},
+		{Time: at(t, "2020-09-17 00:00")},
+	}
+
+	if got := schedule.Upcoming(at(t, "2020-09-14 12:00"), len(want)); !reflect.DeepEqual(got, want) {
+		t.Errorf("upcoming scrapes are %+v, want %+v", got, want)
+	}
+}
+
+func TestScrapeDue(t *testing.T) {
+	tests := []struct {
+		name string
+		// finished is how long ago the live run finished, if one is published
+		finished time.Duration
+		// running is the targets of a run that is still running, if there is one
+		running []string
+		fresh   time.Duration
+		want    bool
+	}{
+		{"nothing published", 0, nil, 12 * time.Hour, true},
+		{"recent", time.Hour, nil, 12 * time.Hour, false},
+		{"stale", 13 * time.Hour, nil, 12 * time.Hour, true},
+		{"always", time.Minute, nil, 0, true},
+		{"recent but interrupted", time.Hour, []string{}, 12 * time.Hour, true},
+		// Scrapes of subjects are not resumed, so they do not make a scrape due
+		{"recent with subjects stopped", time.Hour, []string{"CS"}, 12 * time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			repo := store.NewMemory()
+
+			if test.finished != 0 {
+				gen, err := repo.NewGeneration(ctx, "live")
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if err := repo.PublishGeneration(ctx, gen.Number); err != nil {
+					t.Fatal(err)
+				}
+
+				run := model.Run{ID: "live", Status: model.RunPublished, Generation: gen.Number, Finished: time.Now().Add(-test.finished)}
+				if err := repo.SaveRun(ctx, run); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if test.running != nil {
+				if _, err := newRun(ctx, repo, test.running); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if got := ScrapeDue(ctx, repo, test.fresh); got != test.want {
+				t.Errorf("due is %t, want %t", got, test.want)
+			}
+		})
+	}
+}
@@ -2,29 +2,147 @@ package worker
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
+	"strings"
 	"sync"
 	"time"
+	"uwo-tt-api/logger"
+	"uwo-tt-api/metrics"
 	"uwo-tt-api/model"
+	"uwo-tt-api/store"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// ScrapeTimeTable scraper
-func ScrapeTimeTable(db *mongo.Database) {
+// Settings are how the timetable is scraped and published
+type Settings struct {
+	// URL of the timetable page
+	URL string
+	// Delay between subject pages, so the timetable does not block the scraper
+	Delay time.Duration
+	// Keep is how many published generations are kept to roll back to
+	Keep int
+	// KeepQuarantined is how long generations that were never published are kept for inspection
+	KeepQuarantined time.Duration
+	Thresholds      Thresholds
+}
+
+// ScrapeTimeTable scrapes the timetable into a new generation and publishes it if it meets the thresholds, keeping the latest
+// generations. A generation that fails validation is kept unpublished and its run is quarantined. An interrupted run is
+// resumed from the subjects it has not written yet. It returns the run, which is published unless the scrape failed. When ctx
+// is cancelled the scrape stops after the subject it is writing, leaving the run to be resumed
+func ScrapeTimeTable(ctx context.Context, repo store.Repository, settings Settings) (model.Run, error) {
+	run, err := startRun(ctx, repo)
+	if err != nil {
+		return run, err
+	}
+
+	ctx = withRun(ctx, run)
+
+	// Compare against the live data unless nothing was published yet
+	var live *model.Run
+	if latest, err := repo.LatestRun(ctx); err == nil {
+		live = &latest
+	} else if !errors.Is(err, store.ErrNotFound) {
+		logger.From(ctx).WithError(err).Error("Failed to find live scrape run")
+	}
+
+	err = scrapeRun(ctx, repo, &run, live, settings)
+
+	return run, err
+}
+
+// ScrapeSubjects scrapes only the given subjects into a new generation, which keeps the live sections of every other subject,
+// and publishes it like ScrapeTimeTable. The scraped subjects are compared against their live sections. A cancelled scrape
+// is never resumed, it is abandoned when the next scrape of subjects starts
+func ScrapeSubjects(ctx context.Context, repo store.Repository, subjects []string, settings Settings) (model.Run, error) {
+	if unfinished, err := repo.UnfinishedRun(ctx, true); err == nil {
+		gen, err := store.FindGeneration(ctx, repo, unfinished.Generation)
+		if err != nil && !errors.Is(err, store.ErrNoGeneration) {
+			return model.Run{}, err
+		}
+
+		closeRun(ctx, repo, unfinished, gen)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return model.Run{}, err
+	}
+
+	latest, err := repo.LatestRun(ctx)
+	if errors.Is(err, store.ErrNotFound) {
+		return model.Run{}, errors.New("Nothing is published to merge the subjects into")
+	} else if err != nil {
+		return model.Run{}, err
+	}
+
+	// Sections of the subjects, which are replaced, or of every other subject, which are kept
+	replaced := store.Filter{Any: true}
+	kept := store.Filter{}
+	for _, subject := range subjects {
+		replaced.Conditions = append(replaced.Conditions, store.Condition{Field: "courseData.faculty", Op: store.Eq, Value: subject})
+		kept.Conditions = append(kept.Conditions, store.Condition{Field: "courseData.faculty", Op: store.Ne, Value: subject})
+	}
+
+	sections, err := repo.CountSections(ctx, replaced)
+	if err != nil {
+		return model.Run{}, err
+	}
+
+	run, err := newRun(ctx, repo, subjects)
+	if err != nil {
+		return run, err
+	}
+
+	ctx = withRun(ctx, run)
+
+	copied, err := store.CopySections(ctx, repo, run.Generation, kept)
+	if ctx.Err() != nil {
+		return run, ctx.Err()
+	} else if err != nil {
+		quarantine(ctx, repo, &run, []string{"The live sections could not be copied: " + err.Error()})
+		return run, err
+	}
+
+	logger.From(ctx).Infof("Copied %d live sections", copied)
+
+	// Only the scraped subjects are compared against the live data, so checks of the whole timetable are left out
+	live := &model.Run{Subjects: len(subjects), Sections: int(sections)}
+	settings.Thresholds.MinSubjects, settings.Thresholds.MinSections = 0, 0
+
+	if err := scrapeRun(ctx, repo, &run, live, settings); err != nil {
+		return run, err
+	}
+
+	// The published generation still holds every subject of the live data
+	if run.Status == model.RunPublished {
+		run.Subjects = latest.Subjects
+		if err := repo.SaveRun(ctx, run); err != nil {
+			logger.From(ctx).WithError(err).Error("Failed to record scrape run")
+		}
+	}
+
+	return run, nil
+}
+
+// scrapeRun scrapes the subjects of a run into its generation, skipping those it has written already, then validates it
+// against the live run and publishes it. It only fails when ctx is cancelled, leaving the run unfinished
+func scrapeRun(ctx context.Context, repo store.Repository, run *model.Run, live *model.Run, settings Settings) error {
+	defer observeRun(run, time.Now())
 
 	// Create page to be scraped
 	page := PageScraper{
-		URL: "https://studentservices.uwo.ca/secure/timetables/mastertt/ttindex.cfm/",
-		DB:  db,
+		URL:  settings.URL,
+		Repo: repo,
+		Run:  run,
 	}
 
 	// Fetch document synchronously
-	doc, err := page.FetchDocument()
-	if err != nil {
-		fmt.Println("Error fetching document")
+	doc, err := page.FetchDocument(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	} else if err != nil {
+		logger.From(ctx).WithError(err).Error("Error fetching document")
+		quarantine(ctx, repo, run, []string{"The timetable could not be fetched"})
+		return nil
 	}
 
 	// Find base search form
@@ -50,31 +168,25 @@ func ScrapeTimeTable(db *mongo.Database) {
 	// Loop over all collections and scrape the data based on their selector and store into db. Executed asynchronously
 	for key := range collectionToSelector {
 		wg.Add(1)
-		go page.ScrapeOptToDB(key, collectionToSelector[key], &wg)
+		go page.ScrapeOptToDB(ctx, key, collectionToSelector[key], &wg)
 	}
 
 	// Wait for options to finish scraping to determine time improvments (~140ms -> ~20ms)
 	wg.Wait()
-	fmt.Println("Options scraping:", time.Since(startTime))
+	logger.From(ctx).WithField("duration", time.Since(startTime).Seconds()).Info("Options scraped")
+	metrics.ScrapePhaseDuration.WithLabelValues("options").Observe(time.Since(startTime).Seconds())
 
-	// Grab available subjects from DB
-	collection := page.DB.Collection("subjects")
-	cur, err := collection.Find(context.TODO(), bson.D{})
-	if err != nil {
-		log.Fatal(err)
-	}
+	// Grab available subjects from the form, as the generation is not served yet
+	subjects := page.ScrapeOptions(collectionToSelector["subjects"])
 
-	//Define an array in which you can store the decoded documents
-	var subjects []model.Option
-	for cur.Next(context.TODO()) {
-		//Create a value into which the single document can be decoded
-		var elem model.Option
-		err := cur.Decode(&elem)
-		if err != nil {
-			log.Fatal(err)
+	// A targeted run only scrapes its subjects, all of which must be in the timetable
+	if len(run.Targets) > 0 {
+		var missing []string
+		subjects, missing = selectSubjects(subjects, run.Targets)
+		if len(missing) > 0 {
+			quarantine(ctx, repo, run, []string{"Subjects are not in the timetable: " + strings.Join(missing, ", ")})
+			return nil
 		}
-
-		subjects = append(subjects, elem)
 	}
 
 	// Capture start time for metrics (again)
@@ -82,22 +194,53 @@ func ScrapeTimeTable(db *mongo.Database) {
 
 	// Create channel to be populated with POST results from webpage
 	c := make(chan PageResult)
-	go page.ScrapeCoursesToDB(c, len(subjects))
+	stats := NewScrapeStats()
 
-	// Iterate over all subjects
+	// Subjects written before the run was interrupted are not scraped again, nor is the ANY element
+	done := map[string]bool{"": true}
+	for _, checkpoint := range run.Checkpoints {
+		stats.AddCheckpoint(checkpoint)
+		done[checkpoint.Subject] = true
+	}
+
+	pending := []model.Option{}
 	for _, subject := range subjects {
-		if subject.Data.Value == "" {
-			continue
+		if !done[subject.Data.Value] {
+			pending = append(pending, subject)
 		}
+	}
+
+	wg.Add(1)
+	go page.ScrapeCoursesToDB(ctx, c, len(pending), stats, &wg)
+
+	// Iterate over all subjects
+	for _, subject := range pending {
 
-		// Delay to prevent API from getting blocked
-		time.Sleep(time.Duration(10) * time.Second)
+		// Delay to prevent API from getting blocked, stopping early if the scrape is cancelled
+		select {
+		case <-ctx.Done():
+		case <-time.After(settings.Delay):
+		}
+
+		if ctx.Err() != nil {
+			break
+		}
 
 		// Post to page with subject. Needs to be done synchronously to keep time requirements
 		data := CreateData(subject.Data.Value)
-		doc, err := page.PostDocument(data)
+		fetchTime := time.Now()
+		doc, err := page.PostDocument(ctx, data)
+		if ctx.Err() != nil {
+			break
+		}
+
+		metrics.SubjectFetchDuration.Observe(time.Since(fetchTime).Seconds())
+
 		if err != nil {
-			fmt.Println("Error fetching document")
+			logger.From(ctx).WithField("subject", subject.Data.Value).WithError(err).Error("Error fetching subject")
+			metrics.SubjectErrors.WithLabelValues("fetch").Inc()
+			stats.AddFailed(subject.Data.Value)
+			continue
 		}
 
 		// Add result to channel so that it can be parsed by scrape goroutine
@@ -107,8 +250,177 @@ func ScrapeTimeTable(db *mongo.Database) {
 		}
 	}
 
-	// Close channel afterwards
+	// Close channel afterwards and wait for the last page to be stored
 	close(c)
+	wg.Wait()
+
+	// The subjects written so far are recorded, so a cancelled run is resumed from them
+	if ctx.Err() != nil {
+		logger.From(ctx).Infof("Stopped run after %d subjects", len(run.Checkpoints))
+		return ctx.Err()
+	}
+
+	logger.From(ctx).WithField("duration", time.Since(startTime).Seconds()).Info("Courses scraped")
+	metrics.ScrapePhaseDuration.WithLabelValues("courses").Observe(time.Since(startTime).Seconds())
 
-	fmt.Println("Course scraping:", time.Since(startTime))
+	run.Subjects = stats.Subjects
+	run.Sections = stats.Sections
+
+	if problems := settings.Thresholds.Validate(stats, live); len(problems) > 0 {
+		quarantine(ctx, repo, run, problems)
+		return nil
+	}
+
+	// Serve every collection of the generation at once
+	if err := repo.PublishGeneration(ctx, run.Generation); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to publish generation")
+		return ctx.Err()
+	}
+
+	logger.From(ctx).Info("Published generation")
+
+	sections, err := repo.CountSections(ctx, store.Filter{})
+	if err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to count published sections")
+	}
+
+	run.Finished = time.Now()
+	run.Status = model.RunPublished
+	run.Sections = int(sections)
+
+	metrics.DocumentsPublished.Set(float64(sections))
+	metrics.LastPublished.SetToCurrentTime()
+
+	if err := repo.SaveRun(ctx, *run); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to record scrape run")
+	}
+
+	if err := store.PruneGenerations(ctx, repo, settings.Keep, settings.KeepQuarantined); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to delete old generations")
+	}
+
+	return nil
+}
+
+// withRun returns a copy of ctx whose log lines carry the run and its generation
+func withRun(ctx context.Context, run model.Run) context.Context {
+	return logger.With(logger.With(ctx, "run", run.ID), "generation", run.Generation)
+}
+
+// observeRun times a run by its kind and the status it finished with. A run still running was stopped before it finished
+func observeRun(run *model.Run, start time.Time) {
+	kind := "full"
+	if len(run.Targets) > 0 {
+		kind = "subjects"
+	}
+
+	status := run.Status
+	if status == model.RunRunning {
+		status = "stopped"
+	}
+
+	metrics.ScrapeDuration.WithLabelValues(kind, status).Observe(time.Since(start).Seconds())
+}
+
+// Interrupted reports if a full scrape was interrupted and can be resumed, such as when the instance running it died
+func Interrupted(ctx context.Context, repo store.Repository) bool {
+	_, err := repo.UnfinishedRun(ctx, false)
+
+	return err == nil
+}
+
+// startRun resumes the most recent interrupted full run if its generation is still unpublished. Otherwise it records a new
+// run with a generation of its own, which is only served once it is published. Targeted runs are left to ScrapeSubjects
+func startRun(ctx context.Context, repo store.Repository) (model.Run, error) {
+	run, err := repo.UnfinishedRun(ctx, false)
+	if err == nil {
+		gen, err := store.FindGeneration(ctx, repo, run.Generation)
+		if err != nil && !errors.Is(err, store.ErrNoGeneration) {
+			return run, err
+		} else if err == nil && gen.Published.IsZero() {
+			logger.From(withRun(ctx, run)).Infof("Resuming run, %d subjects already scraped", len(run.Checkpoints))
+			return run, nil
+		}
+
+		// The run was interrupted after publishing or its generation was deleted since
+		closeRun(ctx, repo, run, gen)
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return run, err
+	}
+
+	return newRun(ctx, repo, nil)
+}
+
+// closeRun records a run that stopped and is not resumed as published if its generation was published, or as abandoned
+func closeRun(ctx context.Context, repo store.Repository, run model.Run, gen model.Generation) {
+	run.Finished = time.Now()
+	run.Status = model.RunAbandoned
+	if !gen.Published.IsZero() {
+		run.Status = model.RunPublished
+	}
+
+	if err := repo.SaveRun(ctx, run); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to record scrape run")
+	}
+}
+
+// newRun records a new run with a generation of its own, scraping only the target subjects if there are any
+func newRun(ctx context.Context, repo store.Repository, targets []string) (model.Run, error) {
+
+	// Record the run so that published data can be traced back to it
+	run := model.Run{
+		ID:      primitive.NewObjectID().Hex(),
+		Started: time.Now(),
+		Status:  model.RunRunning,
+		Targets: targets,
+	}
+
+	gen, err := repo.NewGeneration(ctx, run.ID)
+	if err != nil {
+		return run, err
+	}
+
+	run.Generation = gen.Number
+
+	if err := repo.SaveRun(ctx, run); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to record scrape run")
+	}
+
+	return run, nil
+}
+
+// selectSubjects selects the options of the target subjects, returning the targets that have none
+func selectSubjects(subjects []model.Option, targets []string) ([]model.Option, []string) {
+	selected := []model.Option{}
+	missing := []string{}
+
+	for _, target := range targets {
+		found := false
+		for _, subject := range subjects {
+			if subject.Data.Value == target {
+				selected = append(selected, subject)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, target)
+		}
+	}
+
+	return selected, missing
+}
+
+// quarantine records a run that failed validation, leaving its generation unpublished
+func quarantine(ctx context.Context, repo store.Repository, run *model.Run, problems []string) {
+	logger.From(ctx).WithField("problems", strings.Join(problems, "; ")).Warn("Quarantined generation")
+
+	run.Finished = time.Now()
+	run.Status = model.RunQuarantined
+	run.Problems = problems
+
+	if err := repo.SaveRun(ctx, *run); err != nil {
+		logger.From(ctx).WithError(err).Error("Failed to record scrape run")
+	}
 }
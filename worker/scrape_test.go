@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"uwo-tt-api/model"
+	"uwo-tt-api/store"
+)
+
+// timetableRow is a section row of a subject page
+func timetableRow(number string, component string, classNumber int, day string) string {
+	return fmt.Sprintf("<tr><td>%s</td><td>%s</td><td>%d</td><td><table><tr><td>%s</td></tr></table></td><td>9:30 AM</td>"+
+		"<td>10:30 AM</td><td>MC 110</td><td>Smith</td><td></td><td>Not Full</td><td>Main</td><td>In Person</td></tr>",
+		number, component, classNumber, day)
+}
+
+// newTimetable serves a timetable of the subjects. Posting a subject in unreachable drops the connection
+func newTimetable(t *testing.T, subjects []string, unreachable map[string]bool) *httptest.Server {
+	const header = `<div class="page-header"><h1><small>Fall/Winter Academic Timetable2020/2021</small></h1></div>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			options := `<option value="">ANY</option>`
+			for _, subject := range subjects {
+				options += fmt.Sprintf(`<option value="%s">%s</option>`, subject, subject)
+			}
+
+			fmt.Fprintf(w, `<html><body>%s<form id="searchForm"><select id="inputSubject">%s</select>`+
+				`<select id="inputCampus"><option value="Main">Main</option></select></form></body></html>`, header, options)
+			return
+		}
+
+		r.ParseForm()
+		subject := r.FormValue("subject")
+		if unreachable[subject] {
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+
+		i := 0
+		for j, s := range subjects {
+			if s == subject {
+				i = j
+			}
+		}
+
+		fmt.Fprintf(w, `<html><body>%s<div class="span12"><h4>%s 1026A - FUNDAMENTALS</h4><p>desc</p><table><tbody>%s%s</tbody></table></div></body></html>`,
+			header, subject, timetableRow("001", "LEC", i*10+1, "M"), timetableRow("002", "LAB", i*10+2, "W"))
+	}))
+
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// unwritableRepo fails to write the sections of some subjects
+type unwritableRepo struct {
+	store.Repository
+	unwritable map[string]bool
+}
+
+func (r unwritableRepo) WriteSections(ctx context.Context, generation int64, sections []model.Section) error {
+	if len(sections) > 0 && r.unwritable[sections[0].CourseData.Faculty] {
+		return errors.New("disk full")
+	}
+
+	return r.Repository.WriteSections(ctx, generation, sections)
+}
+
+// TestScrapeCountsFailedSubjects fails subjects while fetching and while writing at once, which must all be counted. Run with
+// -race, as failures are recorded by both the fetching and the writing goroutine
+func TestScrapeCountsFailedSubjects(t *testing.T) {
+	subjects := []string{}
+	unreachable := map[string]bool{}
+	unwritable := map[string]bool{}
+
+	for i := 0; i < 60; i++ {
+		subject := fmt.Sprintf("SUBJ%02d", i)
+		subjects = append(subjects, subject)
+
+		// A subject failing to be fetched right after one failing to be written is recorded while the other is
+		switch i % 3 {
+		case 1:
+			unwritable[subject] = true
+		case 2:
+			unreachable[subject] = true
+		}
+	}
+
+	server := newTimetable(t, subjects, unreachable)
+	repo := unwritableRepo{Repository: store.NewMemory(), unwritable: unwritable}
+
+	run, err := ScrapeTimeTable(context.Background(), repo, Settings{
+		URL:        server.URL,
+		Keep:       3,
+		Thresholds: Thresholds{MaxFailedSubjects: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.Status != model.RunQuarantined {
+		t.Fatalf("run is %s, want %s", run.Status, model.RunQuarantined)
+	}
+
+	want := fmt.Sprintf("%d subjects could not be scraped", len(unreachable)+len(unwritable))
+	if len(run.Problems) == 0 || !strings.HasPrefix(run.Problems[0], want) {
+		t.Fatalf("problems are %q, want %q", run.Problems, want)
+	}
+
+	if run.Subjects != len(subjects)-len(unreachable)-len(unwritable) {
+		t.Errorf("scraped %d subjects, want %d", run.Subjects, len(subjects)-len(unreachable)-len(unwritable))
+	}
+}
+
+// TestScrapeResumesFullRunAfterTargetedRun stops a full scrape and then a scrape of a few subjects, which must not hide the
+// full run from the next scrape
+func TestScrapeResumesFullRunAfterTargetedRun(t *testing.T) {
+	ctx := context.Background()
+	server := newTimetable(t, []string{"CS", "MATH"}, nil)
+	settings := Settings{URL: server.URL, Keep: 3}
+	repo := store.NewMemory()
+
+	// The live data the subjects are merged into
+	if _, err := ScrapeTimeTable(ctx, repo, settings); err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := newRun(ctx, repo, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targeted, err := newRun(ctx, repo, []string{"CS"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targeted.Started = full.Started.Add(time.Minute)
+	if err := repo.SaveRun(ctx, targeted); err != nil {
+		t.Fatal(err)
+	}
+
+	if !Interrupted(ctx, repo) {
+		t.Error("the full run is not reported as interrupted")
+	}
+
+	run, err := ScrapeTimeTable(ctx, repo, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.ID != full.ID || run.Status != model.RunPublished {
+		t.Errorf("scraped run %s which is %s, want run %s resumed and published", run.ID, run.Status, full.ID)
+	}
+
+	// The targeted run is abandoned by the next scrape of subjects
+	if unfinished, err := repo.UnfinishedRun(ctx, true); err != nil || unfinished.ID != targeted.ID {
+		t.Fatalf("unfinished targeted run is %s with %v, want %s", unfinished.ID, err, targeted.ID)
+	}
+
+	run, err = ScrapeSubjects(ctx, repo, []string{"MATH"}, settings)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if run.ID == targeted.ID || run.Status != model.RunPublished {
+		t.Errorf("scraped run %s which is %s, want a new run published", run.ID, run.Status)
+	}
+
+	if unfinished, err := repo.UnfinishedRun(ctx, true); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("run %s is still unfinished", unfinished.ID)
+	}
+}
@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"uwo-tt-api/model"
+)
+
+// Thresholds are the limits a scrape must meet to be published. A zero threshold is not checked
+type Thresholds struct {
+	// MinSubjects is the fewest subjects that must be scraped
+	MinSubjects int
+	// MaxFailedSubjects is the most subjects that may fail to be fetched or written
+	MaxFailedSubjects int
+	// MinSections is the fewest sections that must be scraped
+	MinSections int
+	// MinTimesShare is the smallest share of sections that must have meeting times
+	MinTimesShare float64
+	// MaxSubjectErrors is the most parse errors allowed in a single subject
+	MaxSubjectErrors int
+	// MaxDrop is the largest share by which subjects or sections may fall from the live run
+	MaxDrop float64
+}
+
+// ScrapeStats records what a scrape found, to be validated before it is published. Subjects are fetched and written by
+// different goroutines, so the stats are only changed through their methods
+type ScrapeStats struct {
+	mu                sync.Mutex
+	Subjects          int
+	FailedSubjects    []string
+	SubjectErrors     map[string]int
+	Sections          int
+	SectionsWithTimes int
+}
+
+// NewScrapeStats creates empty stats
+func NewScrapeStats() *ScrapeStats {
+	return &ScrapeStats{SubjectErrors: map[string]int{}}
+}
+
+// AddError records a parse error in a subject
+func (stats *ScrapeStats) AddError(subject string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.SubjectErrors[subject]++
+}
+
+// AddFailed records a subject that could not be fetched or written
+func (stats *ScrapeStats) AddFailed(subject string) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.FailedSubjects = append(stats.FailedSubjects, subject)
+}
+
+// AddSubject records the sections written for a subject, returning its checkpoint
+func (stats *ScrapeStats) AddSubject(subject string, sections []model.Section) model.Checkpoint {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	checkpoint := model.Checkpoint{
+		Subject:  subject,
+		Sections: len(sections),
+		Errors:   stats.SubjectErrors[subject],
+	}
+
+	for _, section := range sections {
+		if len(section.SectionData.Times) > 0 {
+			checkpoint.SectionsWithTimes++
+		}
+	}
+
+	stats.addCheckpoint(checkpoint)
+
+	return checkpoint
+}
+
+// AddCheckpoint records a subject written earlier, such as before a run was interrupted
+func (stats *ScrapeStats) AddCheckpoint(checkpoint model.Checkpoint) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+
+	stats.addCheckpoint(checkpoint)
+}
+
+func (stats *ScrapeStats) addCheckpoint(checkpoint model.Checkpoint) {
+	stats.Subjects++
+	stats.Sections += checkpoint.Sections
+	stats.SectionsWithTimes += checkpoint.SectionsWithTimes
+
+	if checkpoint.Errors > 0 {
+		stats.SubjectErrors[checkpoint.Subject] = checkpoint.Errors
+	}
+}
+
+// Validate checks scrape stats against the thresholds and the run of the live data, if any, returning every check that failed
+func (t Thresholds) Validate(stats *ScrapeStats, live *model.Run) []string {
+	problems := []string{}
+
+	if t.MaxFailedSubjects > 0 && len(stats.FailedSubjects) > t.MaxFailedSubjects {
+		problems = append(problems, fmt.Sprintf("%d subjects could not be scraped, at most %d may fail: %s",
+			len(stats.FailedSubjects), t.MaxFailedSubjects, strings.Join(stats.FailedSubjects, ", ")))
+	}
+
+	if t.MinSubjects > 0 && stats.Subjects < t.MinSubjects {
+		problems = append(problems, fmt.Sprintf("Scraped %d subjects, at least %d are required", stats.Subjects, t.MinSubjects))
+	}
+
+	if t.MinSections > 0 && stats.Sections < t.MinSections {
+		problems = append(problems, fmt.Sprintf("Scraped %d sections, at least %d are required", stats.Sections, t.MinSections))
+	}
+
+	if t.MinTimesShare > 0 {
+		share := 0.0
+		if stats.Sections > 0 {
+			share = float64(stats.SectionsWithTimes) / float64(stats.Sections)
+		}
+
+		if share < t.MinTimesShare {
+			problems = append(problems, fmt.Sprintf("%.0f%% of sections have meeting times, at least %.0f%% are required",
+				share*100, t.MinTimesShare*100))
+		}
+	}
+
+	if t.MaxSubjectErrors > 0 {
+		subjects := []string{}
+		for subject := range stats.SubjectErrors {
+			subjects = append(subjects, subject)
+		}
+
+		sort.Strings(subjects)
+
+		for _, subject := range subjects {
+			if errors := stats.SubjectErrors[subject]; errors > t.MaxSubjectErrors {
+				problems = append(problems, fmt.Sprintf("%s has %d parse errors, at most %d are allowed", subject, errors, t.MaxSubjectErrors))
+			}
+		}
+	}
+
+	if t.MaxDrop > 0 && live != nil {
+		if dropped(live.Subjects, stats.Subjects) > t.MaxDrop {
+			problems = append(problems, fmt.Sprintf("Subjects fell from %d to %d", live.Subjects, stats.Subjects))
+		}
+
+		if dropped(live.Sections, stats.Sections) > t.MaxDrop {
+			problems = append(problems, fmt.Sprintf("Sections fell from %d to %d", live.Sections, stats.Sections))
+		}
+	}
+
+	return problems
+}
+
+// dropped returns the share by which a count fell
+func dropped(before, after int) float64 {
+	if before == 0 || after >= before {
+		return 0
+	}
+
+	return float64(before-after) / float64(before)
+}